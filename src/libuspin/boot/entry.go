@@ -0,0 +1,87 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package boot
+
+import (
+	"fmt"
+	"libuspin/config"
+	"strings"
+)
+
+// An Entry is a fully resolved boot menu entry, which each Loader renders
+// into its own configuration format.
+type Entry struct {
+	ID      string  // Unique identifier, safe for use in filenames
+	Title   string  // Text shown in the boot menu
+	Kernel  *Kernel // Kernel to boot
+	Options string  // Complete kernel command line, including root=
+}
+
+// joinCmdline joins the non empty portions of a kernel command line
+func joinCmdline(parts ...string) string {
+	var ret []string
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			ret = append(ret, part)
+		}
+	}
+	return strings.Join(ret, " ")
+}
+
+// GetEntries will return the boot entries for the given install mode. The
+// first entry is always the default, followed by any from the [boot] section,
+// and finally one for each secondary kernel.
+func GetEntries(conf *config.ImageConfiguration, op Capability, c ConfigurationSource) ([]*Entry, error) {
+	root, err := getRootArgument(op, c)
+	if err != nil {
+		return nil, err
+	}
+
+	kernels := c.GetKernels()
+	kernel := kernels[0]
+	entries := []*Entry{
+		{
+			ID:      config.BootEntryDefault,
+			Title:   conf.Branding.StartString,
+			Kernel:  kernel,
+			Options: joinCmdline(root, conf.Boot.Cmdline),
+		},
+	}
+
+	for _, e := range conf.Boot.Entries {
+		cmdline := conf.Boot.Cmdline
+		if e.Cmdline != "" {
+			cmdline = e.Cmdline
+		}
+		entries = append(entries, &Entry{
+			ID:      e.Name,
+			Title:   e.Title,
+			Kernel:  kernel,
+			Options: joinCmdline(root, cmdline, e.Options),
+		})
+	}
+
+	for _, k := range kernels[1:] {
+		entries = append(entries, &Entry{
+			ID:      "kernel-" + k.Version,
+			Title:   fmt.Sprintf("%s (%s)", conf.Branding.StartString, k.Version),
+			Kernel:  k,
+			Options: joinCmdline(root, conf.Boot.Cmdline),
+		})
+	}
+	return entries, nil
+}
@@ -0,0 +1,320 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package boot
+
+import (
+	"errors"
+	"fmt"
+	"github.com/solus-project/libosdev/commands"
+	"github.com/solus-project/libosdev/disk"
+	"libuspin/config"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Grub2Template is used to populate fields in the grub.cfg
+type Grub2Template struct {
+	Title      string
+	Entries    []*Entry // Boot entries, the first being the default
+	SearchUUID string   // Filesystem UUID to search for the kernel, raw disks only
+}
+
+var (
+	// DefaultGrub2Template is the built-in template for grub.cfg
+	DefaultGrub2Template = `# {{.Title}}
+set default=0
+set timeout=5
+
+insmod all_video
+{{- if .SearchUUID}}
+search --no-floppy --set=root --fs-uuid {{.SearchUUID}}
+{{- end}}
+{{- range .Entries}}
+
+menuentry "{{escape .Title}}" --id {{.ID}} {
+  linux /{{.Kernel.TargetPath}} {{.Options}}
+  initrd /{{.Kernel.TargetInitrd}}
+}
+{{- end}}
+`
+)
+
+var (
+	// grub2Escaper escapes the characters that are special within a double
+	// quoted string in grub.cfg
+	grub2Escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`)
+)
+
+// parseGrub2Template will parse the grub.cfg template with the escape
+// function available to it
+func parseGrub2Template(source string) (*template.Template, error) {
+	return template.New("grub.cfg").Funcs(template.FuncMap{
+		"escape": grub2Escaper.Replace,
+	}).Parse(source)
+}
+
+const (
+	// grub2PlatformLegacy is the platform directory for BIOS booting
+	grub2PlatformLegacy = "i386-pc"
+
+	// grub2PlatformEFI is the platform directory for x86_64 UEFI booting
+	grub2PlatformEFI = "x86_64-efi"
+)
+
+var (
+	// Grub2Paths contains the module directories known to be used by the
+	// majority of Linux distributions
+	Grub2Paths = []string{
+		"/usr/lib/grub",
+		"/usr/lib/grub2",
+		"/usr/lib64/grub",
+		"/usr/share/grub2",
+	}
+
+	// Grub2ModulesISO are the modules built into the El Torito image
+	Grub2ModulesISO = []string{
+		"biosdisk",
+		"iso9660",
+		"part_msdos",
+		"part_gpt",
+		"normal",
+		"configfile",
+		"search",
+		"search_label",
+		"search_fs_uuid",
+		"linux",
+		"all_video",
+		"echo",
+	}
+
+	// Grub2ModulesEFI are the modules built into the EFI image
+	Grub2ModulesEFI = []string{
+		"fat",
+		"iso9660",
+		"part_msdos",
+		"part_gpt",
+		"normal",
+		"configfile",
+		"search",
+		"search_label",
+		"search_fs_uuid",
+		"linux",
+		"all_video",
+		"efi_gop",
+		"efi_uga",
+		"echo",
+	}
+)
+
+// Grub2Loader provides GRUB 2 support for legacy and UEFI booting of both
+// ISOs and raw disks
+type Grub2Loader struct {
+	// Host tool names differ between distributions, i.e. grub2-mkimage
+	mkimage string
+	install string
+
+	// Store the configuration for particulars we need to implement
+	config *config.ImageConfiguration
+
+	grubTemplate *template.Template
+}
+
+// NewGrub2Loader will return a newly created Grub2Loader instance
+func NewGrub2Loader() *Grub2Loader {
+	return &Grub2Loader{}
+}
+
+// lookTool returns the first of the given tool names found on the host
+func lookTool(names ...string) string {
+	for _, name := range names {
+		if _, err := exec.LookPath(name); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// Init will ensure the GRUB host tooling is available
+func (g *Grub2Loader) Init(c *config.ImageConfiguration) error {
+	if g.mkimage = lookTool("grub-mkimage", "grub2-mkimage"); g.mkimage == "" {
+		return errors.New("Cannot find grub-mkimage on the host")
+	}
+	// Only needed for raw disks, so checked at install time
+	g.install = lookTool("grub-install", "grub2-install")
+
+	tmpl, err := parseGrub2Template(DefaultGrub2Template)
+	if err != nil {
+		return err
+	}
+	g.grubTemplate = tmpl
+	g.config = c
+	return nil
+}
+
+// GetCapabilities will return full support for GRUB
+func (g *Grub2Loader) GetCapabilities() Capability {
+	return CapInstallUEFI | CapInstallLegacy | CapInstallISO | CapInstallRaw
+}
+
+// platformDir will locate the module directory for the given platform
+func (g *Grub2Loader) platformDir(platform string) (string, error) {
+	for _, path := range Grub2Paths {
+		fpath := filepath.Join(path, platform)
+		if _, err := os.Stat(fpath); err == nil {
+			return fpath, nil
+		}
+	}
+	return "", fmt.Errorf("Cannot find GRUB modules for platform: %v", platform)
+}
+
+// mkImage will build a core image for the platform with the given prefix
+func (g *Grub2Loader) mkImage(platform, format, prefix, output string, modules []string) error {
+	dir, err := g.platformDir(platform)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(output), 00755); err != nil {
+		return err
+	}
+	args := []string{
+		"-d", dir,
+		"-O", format,
+		"-p", prefix,
+		"-o", output,
+	}
+	args = append(args, modules...)
+	return commands.ExecStdoutArgs(g.mkimage, args)
+}
+
+// writeConfig will write the grub.cfg to the given path
+func (g *Grub2Loader) writeConfig(op Capability, c ConfigurationSource, path string) error {
+	entries, err := GetEntries(g.config, op, c)
+	if err != nil {
+		return err
+	}
+
+	tmplData := Grub2Template{
+		Title:   g.config.Branding.Title,
+		Entries: entries,
+	}
+	if op&CapInstallRaw == CapInstallRaw {
+		if tmplData.SearchUUID, err = GetDeviceUUID(c.GetBootDevice()); err != nil {
+			return err
+		}
+	}
+	return writeTemplate(g.grubTemplate, path, tmplData)
+}
+
+// Install will do the real work of installing GRUB for the given mode
+func (g *Grub2Loader) Install(op Capability, c ConfigurationSource) error {
+	if op&CapInstallRaw == CapInstallRaw {
+		return g.installRaw(op, c)
+	}
+
+	// UEFI ISO installs happen within the efi.img tree
+	if op&CapInstallUEFI == CapInstallUEFI {
+		efiDir := c.JoinDeployPath("EFI", "Boot")
+		if err := g.mkImage(grub2PlatformEFI, "x86_64-efi", "/EFI/Boot", filepath.Join(efiDir, "BOOTX64.EFI"), Grub2ModulesEFI); err != nil {
+			return err
+		}
+		return g.writeConfig(op, c, filepath.Join(efiDir, "grub.cfg"))
+	}
+
+	// Legacy ISO
+	grubDir := c.JoinDeployPath("boot", "grub")
+	if err := g.mkImage(grub2PlatformLegacy, "i386-pc-eltorito", "/boot/grub", filepath.Join(grubDir, "eltorito.img"), Grub2ModulesISO); err != nil {
+		return err
+	}
+	platformDir, err := g.platformDir(grub2PlatformLegacy)
+	if err != nil {
+		return err
+	}
+	if err := disk.CopyFile(filepath.Join(platformDir, "boot_hybrid.img"), filepath.Join(grubDir, "boot_hybrid.img")); err != nil {
+		return err
+	}
+	return g.writeConfig(op, c, filepath.Join(grubDir, "grub.cfg"))
+}
+
+// parentDisk returns the whole disk device for a partition device
+func parentDisk(partition string) (string, error) {
+	out, err := exec.Command("lsblk", "-no", "pkname", partition).Output()
+	if err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(string(out))
+	if name == "" {
+		return "", fmt.Errorf("Cannot find parent disk of %v", partition)
+	}
+	return filepath.Join("/dev", name), nil
+}
+
+// installRaw will use grub-install for each requested firmware type
+func (g *Grub2Loader) installRaw(op Capability, c ConfigurationSource) error {
+	if g.install == "" {
+		return errors.New("Cannot find grub-install on the host")
+	}
+	bootDir := c.JoinRootPath("boot")
+
+	if op&CapInstallUEFI == CapInstallUEFI {
+		args := []string{
+			"--target=" + grub2PlatformEFI,
+			"--efi-directory=" + c.JoinDeployPath(),
+			"--boot-directory=" + bootDir,
+			"--removable",
+			"--no-nvram",
+		}
+		if err := commands.ExecStdoutArgs(g.install, args); err != nil {
+			return err
+		}
+	}
+
+	if op&CapInstallLegacy == CapInstallLegacy {
+		device, err := parentDisk(c.GetRootDevice())
+		if err != nil {
+			return err
+		}
+		args := []string{
+			"--target=" + grub2PlatformLegacy,
+			"--boot-directory=" + bootDir,
+			device,
+		}
+		if err := commands.ExecStdoutArgs(g.install, args); err != nil {
+			return err
+		}
+	}
+
+	return g.writeConfig(op, c, filepath.Join(bootDir, "grub", "grub.cfg"))
+}
+
+// GetSpecialFile will return the special paths for GRUB on an ISO
+func (g *Grub2Loader) GetSpecialFile(t FileType) string {
+	switch t {
+	case FileTypeBootElToritoBinary:
+		return filepath.Join("boot", "grub", "eltorito.img")
+	case FileTypeBootElToritoCatalog:
+		return filepath.Join("boot", "grub", "boot.cat")
+	case FileTypeBootGrub2MBR:
+		return filepath.Join("boot", "grub", "boot_hybrid.img")
+	case FileTypeBootEFIImage:
+		return filepath.Join("boot", "grub", "efi.img")
+	default:
+		return ""
+	}
+}
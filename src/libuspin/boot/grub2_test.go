@@ -0,0 +1,86 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package boot
+
+import (
+	"libuspin/config"
+	"testing"
+)
+
+func TestGrub2Escape(t *testing.T) {
+	tests := []struct {
+		title    string
+		expected string
+	}{
+		{"Start Solus", "Start Solus"},
+		{`Start "Solus"`, `Start \"Solus\"`},
+		{`C:\ $HOME`, `C:\\ \$HOME`},
+	}
+	for _, test := range tests {
+		if got := grub2Escaper.Replace(test.title); got != test.expected {
+			t.Fatalf("Wrong escape of %v: %v", test.title, got)
+		}
+	}
+}
+
+func TestGrub2Config(t *testing.T) {
+	s := newTestSource(t, "4.8.12-11.current", "4.4.38-30.lts")
+	defer s.Close()
+
+	tmpl, err := parseGrub2Template(DefaultGrub2Template)
+	if err != nil {
+		t.Fatalf("Cannot parse grub.cfg template: %v", err)
+	}
+	c := &config.ImageConfiguration{}
+	c.Branding.Title = "Solus"
+	c.Branding.StartString = `Start "Solus"`
+	c.Boot.Cmdline = "quiet"
+	c.Boot.Entries = []config.SectionBootEntry{
+		{Name: "safe-graphics", Title: "Safe graphics", Options: "nomodeset"},
+	}
+	g := &Grub2Loader{config: c, grubTemplate: tmpl}
+
+	path := s.JoinDeployPath("boot", "grub", "grub.cfg")
+	if err := g.writeConfig(CapInstallLegacy|CapInstallISO, s, path); err != nil {
+		t.Fatalf("Failed to write grub.cfg: %v", err)
+	}
+
+	expected := `# Solus
+set default=0
+set timeout=5
+
+insmod all_video
+
+menuentry "Start \"Solus\"" --id live {
+  linux /kernel-4.8.12-11.current root=live:CDLABEL=SOLUS quiet
+  initrd /initrd-4.8.12-11.current
+}
+
+menuentry "Safe graphics" --id safe-graphics {
+  linux /kernel-4.8.12-11.current root=live:CDLABEL=SOLUS quiet nomodeset
+  initrd /initrd-4.8.12-11.current
+}
+
+menuentry "Start \"Solus\" (4.4.38-30.lts)" --id kernel-4.4.38-30.lts {
+  linux /kernel-4.4.38-30.lts root=live:CDLABEL=SOLUS quiet
+  initrd /initrd-4.4.38-30.lts
+}
+`
+	if got := readTestFile(t, path); got != expected {
+		t.Fatalf("Wrong grub.cfg:\n%v\nexpected:\n%v", got, expected)
+	}
+}
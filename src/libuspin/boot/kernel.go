@@ -18,68 +18,302 @@ package boot
 
 import (
 	"errors"
+	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 // A Kernel is exactly what it looks like. :p
 type Kernel struct {
-	Version      string
+	Version      string // Version as used by /lib/modules, and thus dracut
+	Upstream     string // Upstream kernel version, i.e. 4.8.12
+	Release      int    // Package release number, if known
+	Flavour      string // Kernel flavour, i.e. "lts" or "current", if known
 	Path         string
 	BaseName     string
 	TargetPath   string // Relative path within the filesystem
 	TargetInitrd string // Relative initrd path within the filesystem
+
+	numbers []int // Parsed version numbers for sorting
 }
 
 var (
 	// ErrNoKernelFound is returned when a builder cannot find a kernel in the given root
 	ErrNoKernelFound = errors.New("Could not find a valid kernel")
+
+	// KernelPaths are the directories searched for kernels within the rootfs
+	KernelPaths = []string{
+		"/boot",
+		"/usr/lib/kernel",
+	}
+
+	// ModulePaths are the directories searched for kernel modules
+	ModulePaths = []string{
+		"/lib/modules",
+		"/usr/lib/modules",
+	}
+
+	// kernelPrefixes are the known file name prefixes of kernel images
+	kernelPrefixes = []string{
+		"vmlinuz-",
+		"kernel-",
+		"vmlinux-",
+		"com.solus-project.",
+		"org.clearlinux.",
+	}
+
+	// kernelVersionRegex handles both <version>[.-<release>][-<flavour>] as used
+	// in /boot by Solus, and <flavour>.<version>-<release> as used by the
+	// clr-boot-manager scheme in /usr/lib/kernel.
+	kernelVersionRegex   = regexp.MustCompile(`^(\d+\.\d+(?:\.\d+)?)(?:[.-](\d+))?(?:[.-]([A-Za-z][A-Za-z0-9_]*))?$`)
+	kernelFlavouredRegex = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9_]*)\.(\d+\.\d+(?:\.\d+)?)(?:-(\d+))?$`)
+
+	// kernelLooseRegex allows for any other distribution specific suffix
+	kernelLooseRegex = regexp.MustCompile(`^(\d+\.\d+(?:\.\d+)?)(?:[-._+~]|$)`)
 )
 
-// GetKernelFromRoot will attempt to "learn" about the kernel from the rootfs
-// and return a populated kernel struct.
-func GetKernelFromRoot(root string) (*Kernel, error) {
-	// Add more as time goes by.
+// ParseKernelName will attempt to parse the version, release and flavour
+// from the kernel file name, returning nil if it isn't a known kernel name.
+func ParseKernelName(name string) *Kernel {
+	suffix := ""
+	for _, prefix := range kernelPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			suffix = name[len(prefix):]
+			break
+		}
+	}
+	if suffix == "" {
+		return nil
+	}
+
+	k := &Kernel{BaseName: name}
+	if m := kernelVersionRegex.FindStringSubmatch(suffix); m != nil {
+		k.Upstream = m[1]
+		k.Release, _ = strconv.Atoi(m[2])
+		k.Flavour = m[3]
+	} else if m := kernelFlavouredRegex.FindStringSubmatch(suffix); m != nil {
+		k.Flavour = m[1]
+		k.Upstream = m[2]
+		k.Release, _ = strconv.Atoi(m[3])
+	} else if m := kernelLooseRegex.FindStringSubmatch(suffix); m != nil {
+		k.Upstream = m[1]
+	} else {
+		return nil
+	}
+
+	for _, field := range strings.Split(k.Upstream, ".") {
+		n, _ := strconv.Atoi(field)
+		k.numbers = append(k.numbers, n)
+	}
+	return k
+}
+
+// Newer determines whether k is a newer kernel than k2
+func (k *Kernel) Newer(k2 *Kernel) bool {
+	for i := 0; i < len(k.numbers) && i < len(k2.numbers); i++ {
+		if k.numbers[i] != k2.numbers[i] {
+			return k.numbers[i] > k2.numbers[i]
+		}
+	}
+	if len(k.numbers) != len(k2.numbers) {
+		return len(k.numbers) > len(k2.numbers)
+	}
+	return k.Release > k2.Release
+}
+
+// Matches determines whether the kernel is selected by the given name, which
+// may be the flavour, file name, or a version prefix.
+func (k *Kernel) Matches(selector string) bool {
+	return selector == k.Flavour || selector == k.BaseName || selector == k.Version ||
+		selector == k.Upstream || strings.HasPrefix(k.Upstream, selector+".")
+}
+
+// findModulesVersion will find the name of the modules directory for this
+// kernel, which is what dracut expects as the version.
+func (k *Kernel) findModulesVersion(root string) string {
+	release := ""
+	if k.Release > 0 {
+		release = strconv.Itoa(k.Release)
+	}
+	suffix := strings.TrimPrefix(k.BaseName, kernelPrefix(k.BaseName))
+	candidate := ""
+
+	for _, path := range ModulePaths {
+		entries, err := ioutil.ReadDir(filepath.Join(root, path))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.IsDir() || !strings.HasPrefix(name, k.Upstream) {
+				continue
+			}
+			// An exact match always wins
+			if name == suffix {
+				return name
+			}
+			// Don't let 4.8.1 match 4.8.12
+			rest := name[len(k.Upstream):]
+			if rest != "" && rest[0] >= '0' && rest[0] <= '9' {
+				continue
+			}
+			if !strings.Contains(rest, release) || !strings.Contains(rest, k.Flavour) {
+				continue
+			}
+			if candidate == "" {
+				candidate = name
+			}
+		}
+	}
+	return candidate
+}
+
+// byNewest sorts kernels with the newest first
+type byNewest []*Kernel
+
+func (b byNewest) Len() int           { return len(b) }
+func (b byNewest) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byNewest) Less(i, j int) bool { return b[i].Newer(b[j]) }
+
+// ScanKernels will enumerate all kernels within the rootfs, sorted with the
+// newest first.
+func ScanKernels(root string) ([]*Kernel, error) {
+	var kernels []*Kernel
+	seen := make(map[string]bool)
+
+	for _, dir := range KernelPaths {
+		entries, err := ioutil.ReadDir(filepath.Join(root, dir))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, entry := range entries {
+			path, err := filepath.EvalSymlinks(filepath.Join(root, dir, entry.Name()))
+			if err != nil {
+				continue
+			}
+			if seen[path] {
+				continue
+			}
+			if st, err := os.Stat(path); err != nil || !st.Mode().IsRegular() {
+				continue
+			}
+			k := ParseKernelName(filepath.Base(path))
+			if k == nil {
+				continue
+			}
+			seen[path] = true
+			k.Path = path
+
+			// Without a matching modules directory we can only assume the
+			// file name suffix is the version, as we always used to.
+			if k.Version = k.findModulesVersion(root); k.Version == "" {
+				k.Version = strings.TrimPrefix(k.BaseName, kernelPrefix(k.BaseName))
+			}
+
+			log.WithFields(log.Fields{
+				"kernel":  k.BaseName,
+				"version": k.Version,
+			}).Info("Discovered usable kernel")
+			kernels = append(kernels, k)
+		}
+	}
+
+	if len(kernels) == 0 {
+		return nil, ErrNoKernelFound
+	}
+	sort.Stable(byNewest(kernels))
+	return kernels, nil
+}
+
+// kernelPrefix returns the known prefix of a kernel name
+func kernelPrefix(name string) string {
+	for _, prefix := range kernelPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// defaultKernel returns the kernel pointed to by the /vmlinuz style symlinks
+// if present, otherwise the newest kernel.
+func defaultKernel(root string, kernels []*Kernel) *Kernel {
 	possiblePaths := []string{
 		filepath.Join(root, "vmlinuz"),
 		filepath.Join(root, "boot", "vmlinuz"),
 	}
-
 	for _, p := range possiblePaths {
 		// as an example, /vmlinuz -> boot/kernel-4.8.10
-		if kpath, err := filepath.EvalSymlinks(p); err == nil {
-			p = kpath
-		} else {
-			return nil, err
+		kpath, err := filepath.EvalSymlinks(p)
+		if err != nil {
+			continue
+		}
+		for _, k := range kernels {
+			if k.Path == kpath {
+				return k
+			}
 		}
+	}
+	return kernels[0]
+}
 
-		st, err := os.Stat(p)
-		if err != nil || st == nil {
-			continue
+// SelectKernels will return the kernels from the rootfs to be used for booting.
+// The first kernel is always the default, chosen by the def selector if set,
+// followed by any secondary kernels chosen by the extra selectors.
+func SelectKernels(root, def string, extra []string) ([]*Kernel, error) {
+	kernels, err := ScanKernels(root)
+	if err != nil {
+		return nil, err
+	}
+
+	find := func(selector string) (*Kernel, error) {
+		for _, k := range kernels {
+			if k.Matches(selector) {
+				return k, nil
+			}
 		}
+		return nil, fmt.Errorf("No kernel found matching: %v", selector)
+	}
 
-		// TODO: Add more scan thingers.
-		baseNom := filepath.Base(p)
-		splits := strings.Split(p, "-")
-		if len(splits) < 2 {
-			log.WithFields(log.Fields{
-				"kernel": baseNom,
-			}).Warning("Don't know how to handle kernel version")
-			continue
+	var primary *Kernel
+	if def == "" {
+		primary = defaultKernel(root, kernels)
+	} else if primary, err = find(def); err != nil {
+		return nil, err
+	}
+
+	ret := []*Kernel{primary}
+	for _, selector := range extra {
+		k, err := find(selector)
+		if err != nil {
+			return nil, err
+		}
+		dupe := false
+		for _, k2 := range ret {
+			dupe = dupe || k2 == k
+		}
+		if !dupe {
+			ret = append(ret, k)
 		}
-		version := strings.Join(splits[1:], "-")
-		log.WithFields(log.Fields{
-			"kernel":  baseNom,
-			"version": version,
-		}).Info("Discovered usable kernel")
-		return &Kernel{
-			Version:  version,
-			BaseName: baseNom,
-			Path:     p,
-		}, nil
-	}
-
-	return nil, ErrNoKernelFound
+	}
+	return ret, nil
+}
+
+// GetKernelFromRoot will attempt to "learn" about the kernel from the rootfs
+// and return a populated kernel struct for the default kernel.
+func GetKernelFromRoot(root string) (*Kernel, error) {
+	kernels, err := SelectKernels(root, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return kernels[0], nil
 }
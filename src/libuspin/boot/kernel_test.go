@@ -0,0 +1,101 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package boot
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseKernelName(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream string
+		release  int
+		flavour  string
+	}{
+		{"kernel-4.8.10", "4.8.10", 0, ""},
+		{"kernel-4.8.12.11-current", "4.8.12", 11, "current"},
+		{"vmlinuz-4.4.0-53-generic", "4.4.0", 53, "generic"},
+		{"com.solus-project.lts.4.4.38-30", "4.4.38", 30, "lts"},
+		{"vmlinuz-4.8.6-300.fc25.x86_64", "4.8.6", 0, ""},
+	}
+	for _, test := range tests {
+		k := ParseKernelName(test.name)
+		if k == nil {
+			t.Fatalf("Failed to parse kernel name: %v", test.name)
+		}
+		if k.Upstream != test.upstream || k.Release != test.release || k.Flavour != test.flavour {
+			t.Fatalf("Wrong parse of %v: %v %v %v", test.name, k.Upstream, k.Release, k.Flavour)
+		}
+	}
+	for _, name := range []string{"vmlinuz", "initramfs-4.8.10.img", "config-4.8.10"} {
+		if k := ParseKernelName(name); k != nil {
+			t.Fatalf("Should not have parsed as a kernel: %v", name)
+		}
+	}
+}
+
+func TestSelectKernels(t *testing.T) {
+	root, err := ioutil.TempDir("", "uspin-kernels")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	files := []string{
+		"usr/lib/kernel/com.solus-project.lts.4.4.38-30",
+		"usr/lib/kernel/com.solus-project.current.4.8.12-11",
+		"usr/lib/kernel/cmdline-4.8.12-11.current",
+	}
+	for _, f := range files {
+		if err := os.MkdirAll(filepath.Join(root, filepath.Dir(f)), 00755); err != nil {
+			t.Fatalf("Cannot create directory: %v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(root, f), []byte(f), 00644); err != nil {
+			t.Fatalf("Cannot write file: %v", err)
+		}
+	}
+	for _, d := range []string{"4.4.38-30.lts", "4.8.12-11.current"} {
+		if err := os.MkdirAll(filepath.Join(root, "lib", "modules", d), 00755); err != nil {
+			t.Fatalf("Cannot create directory: %v", err)
+		}
+	}
+
+	// No /vmlinuz symlink, so newest wins
+	kernels, err := SelectKernels(root, "", nil)
+	if err != nil {
+		t.Fatalf("Failed to select kernels: %v", err)
+	}
+	if len(kernels) != 1 || kernels[0].Version != "4.8.12-11.current" {
+		t.Fatalf("Wrong default kernel: %v", kernels[0].Version)
+	}
+
+	kernels, err = SelectKernels(root, "lts", []string{"current", "4.4"})
+	if err != nil {
+		t.Fatalf("Failed to select kernels: %v", err)
+	}
+	if len(kernels) != 2 || kernels[0].Version != "4.4.38-30.lts" || kernels[1].Flavour != "current" {
+		t.Fatalf("Wrong kernel selection: %v", kernels)
+	}
+
+	if _, err := SelectKernels(root, "4.9", nil); err == nil {
+		t.Fatalf("Should not find a missing kernel")
+	}
+}
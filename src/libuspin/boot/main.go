@@ -20,7 +20,13 @@ package boot
 
 import (
 	"errors"
+	"fmt"
 	"libuspin/config"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
 )
 
 // A FileType is a named special file
@@ -39,6 +45,14 @@ const (
 	// FileTypeBootMBR is the ISO MBR file. This permits hybrid ISO generation for
 	// both USB & CD.
 	FileTypeBootMBR FileType = "boot.mbr"
+
+	// FileTypeBootEFIImage is the FAT image containing the EFI loader, kernel and
+	// initrd, used as the alternative El Torito boot image for UEFI booting.
+	FileTypeBootEFIImage FileType = "efi.img"
+
+	// FileTypeBootGrub2MBR is GRUB's hybrid MBR, which unlike FileTypeBootMBR must
+	// be patched with the location of the El Torito image by xorriso.
+	FileTypeBootGrub2MBR FileType = "boot_hybrid.img"
 )
 
 // A Loader provides abstraction around various bootloader implementations.
@@ -84,6 +98,9 @@ type ConfigurationSource interface {
 	// GetKernel should return the default kernel, configured with the correct
 	// asset path
 	GetKernel() *Kernel
+
+	// GetKernels should return all bootable kernels, the default being first
+	GetKernels() []*Kernel
 }
 
 // Capability refers to the type of operations that a bootloader supports
@@ -116,6 +133,10 @@ func NewLoader(impl config.LoaderType) (Loader, error) {
 	switch impl {
 	case config.LoaderTypeSyslinux:
 		return NewSyslinuxLoader(), nil
+	case config.LoaderTypeSystemdBoot:
+		return NewSystemdBootLoader(), nil
+	case config.LoaderTypeGrub2:
+		return NewGrub2Loader(), nil
 	default:
 		return nil, ErrUnknownLoader
 	}
@@ -157,3 +178,44 @@ func GetLoaderWithMask(loaders []Loader, mask Capability) Loader {
 func HaveLoaderWithMask(loaders []Loader, mask Capability) bool {
 	return GetLoaderWithMask(loaders, mask) != nil
 }
+
+// GetDeviceUUID will return the filesystem UUID of the given block device, so
+// that bootloaders installing to raw disks can find the root filesystem no
+// matter which device it appears as at boot time.
+func GetDeviceUUID(device string) (string, error) {
+	out, err := exec.Command("blkid", "-s", "UUID", "-o", "value", device).Output()
+	if err != nil {
+		return "", err
+	}
+	uuid := strings.TrimSpace(string(out))
+	if uuid == "" {
+		return "", fmt.Errorf("No filesystem UUID found for %v", device)
+	}
+	return uuid, nil
+}
+
+// getRootArgument returns the root= kernel argument used to find the root
+// filesystem for the given install mode.
+func getRootArgument(op Capability, c ConfigurationSource) (string, error) {
+	if op&CapInstallRaw != CapInstallRaw {
+		return fmt.Sprintf("root=live:CDLABEL=%s", c.GetRootDevice()), nil
+	}
+	uuid, err := GetDeviceUUID(c.GetRootDevice())
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("root=UUID=%s", uuid), nil
+}
+
+// writeTemplate will execute tmpl with data into the file at path
+func writeTemplate(tmpl *template.Template, path string, data interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 00755); err != nil {
+		return err
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return tmpl.Execute(out, data)
+}
@@ -19,6 +19,7 @@ package boot
 import (
 	"fmt"
 	"github.com/solus-project/libosdev/disk"
+	"io/ioutil"
 	"libuspin/config"
 	"os"
 	"path/filepath"
@@ -31,17 +32,24 @@ type IsolinuxTemplate struct {
 	Label       string // CDLABEL
 	Title       string // Needs to come from config!
 	StartString string
+	Timeout     int    // Menu timeout in 1/10th seconds
+	Background  string // Splash image within the isolinux directory, if any
+	Entries     []config.SectionIsolinuxEntry
+	BootEntries []*Entry // Kernel boot entries, the first being the default
 }
 
 var (
 	// DefaultIsolinuxTemplate is the built-in template for isolinux.cfg
 	DefaultIsolinuxTemplate = `
 ui vesamenu.c32
-timeout 50
+timeout {{.Timeout}}
 default live
 
 MENU RESOLUTION 1024 768
 menu title {{.Title}}
+{{- if .Background}}
+MENU BACKGROUND {{.Background}}
+{{- end}}
 
 menu color screen       37;40      #80ffffff #00000000 std
 MENU COLOR border       30;44   #40ffffff #a0000000 std
@@ -62,11 +70,23 @@ MENU VSHIFT 17
 MENU HSHIFT 25
 MENU TABMSGROW 11
 
-label live
-  menu label {{.StartString}}
-  kernel /{{.Kernel.TargetPath}}
-  append initrd=/{{.Kernel.TargetInitrd}} root=live:CDLABEL={{.Label}} ro rd.luks=0 rd.md=0 quiet splash --
+{{- range $i, $e := .BootEntries}}
+label {{$e.ID}}
+  menu label {{$e.Title}}
+  kernel /{{$e.Kernel.TargetPath}}
+  append initrd=/{{$e.Kernel.TargetInitrd}} {{$e.Options}} --
+{{- if eq $i 0}}
 menu default
+{{- end}}
+{{- end}}
+{{- range .Entries}}
+label {{.Name}}
+  menu label {{.Title}}
+  kernel {{.Kernel}}
+  {{- if .Append}}
+  append {{.Append}}
+  {{- end}}
+{{- end}}
 label local
   menu label Boot from local drive
   localboot 0x80
@@ -108,6 +128,9 @@ type SyslinuxLoader struct {
 	// Store the configuration for particulars we need to implement
 	config *config.ImageConfiguration
 
+	// Assets required by the configuration, i.e. hdt.c32
+	extraAssets []string
+
 	isolinuxTemplate *template.Template
 }
 
@@ -141,9 +164,22 @@ func (s *SyslinuxLoader) Init(c *config.ImageConfiguration) error {
 			return err
 		}
 	}
-	// Parse our default templates
-	// TODO: Make it possible to load from a file!
-	tmpl, err := template.New("isolinux").Parse(DefaultIsolinuxTemplate)
+	// Any .c32 modules used by extra entries must be shipped too
+	for _, entry := range c.Isolinux.Entries {
+		if filepath.Ext(entry.Kernel) != ".c32" {
+			continue
+		}
+		if err := s.LocateAsset(entry.Kernel); err != nil {
+			return err
+		}
+		s.extraAssets = append(s.extraAssets, entry.Kernel)
+	}
+	// Custom templates are already validated when loading the config
+	source := DefaultIsolinuxTemplate
+	if c.Isolinux.TemplateData != "" {
+		source = c.Isolinux.TemplateData
+	}
+	tmpl, err := template.New("isolinux").Parse(source)
 	if err != nil {
 		return err
 	}
@@ -183,6 +219,7 @@ func (s *SyslinuxLoader) Install(op Capability, c ConfigurationSource) error {
 	for _, key := range SyslinuxAssetsISO {
 		reqAssets = append(reqAssets, key)
 	}
+	reqAssets = append(reqAssets, s.extraAssets...)
 
 	// Install the ISO assets
 	for _, asset := range reqAssets {
@@ -192,16 +229,35 @@ func (s *SyslinuxLoader) Install(op Capability, c ConfigurationSource) error {
 		}
 	}
 
+	// Install the splash image if we have one
+	background := ""
+	if s.config.Isolinux.Background != "" {
+		background = filepath.Base(s.config.Isolinux.Background)
+		target := c.JoinDeployPath("isolinux", background)
+		if err := disk.CopyFile(s.config.Isolinux.Background, target); err != nil {
+			return err
+		}
+	}
+
 	brand := s.config.Branding.Title
 	str := s.config.Branding.StartString
 	label := c.GetRootDevice()
 
+	entries, err := GetEntries(s.config, op, c)
+	if err != nil {
+		return err
+	}
+
 	// Write our template data
 	tmplData := IsolinuxTemplate{
 		Kernel:      c.GetKernel(),
 		Label:       label,
 		Title:       brand,
 		StartString: str,
+		Timeout:     s.config.Isolinux.Timeout,
+		Background:  background,
+		Entries:     s.config.Isolinux.Entries,
+		BootEntries: entries,
 	}
 
 	cfg := c.JoinDeployPath("isolinux", "isolinux.cfg")
@@ -213,6 +269,55 @@ func (s *SyslinuxLoader) Install(op Capability, c ConfigurationSource) error {
 	return s.isolinuxTemplate.Execute(out, tmplData)
 }
 
+// ValidateIsolinuxTemplate will execute the configured isolinux template
+// against representative data, so that a template referring to fields or
+// entries that do not exist fails when the configuration is loaded rather
+// than part way through a build.
+func ValidateIsolinuxTemplate(c *config.ImageConfiguration) error {
+	if c.Isolinux.TemplateData == "" {
+		return nil
+	}
+	tmpl, err := template.New("isolinux").Parse(c.Isolinux.TemplateData)
+	if err != nil {
+		return &config.KeyError{Key: "isolinux.template", Message: err.Error()}
+	}
+	kernel := &Kernel{
+		Version:      "4.8.12-11.current",
+		Upstream:     "4.8.12",
+		Release:      11,
+		Flavour:      "current",
+		Path:         "/boot/kernel-4.8.12-11.current",
+		BaseName:     "kernel-4.8.12-11.current",
+		TargetPath:   "kernel",
+		TargetInitrd: "initrd.img",
+	}
+	background := ""
+	if c.Isolinux.Background != "" {
+		background = filepath.Base(c.Isolinux.Background)
+	}
+	tmplData := IsolinuxTemplate{
+		Kernel:      kernel,
+		Label:       c.LiveOS.Label,
+		Title:       c.Branding.Title,
+		StartString: c.Branding.StartString,
+		Timeout:     c.Isolinux.Timeout,
+		Background:  background,
+		Entries:     c.Isolinux.Entries,
+		BootEntries: []*Entry{
+			{
+				ID:      config.BootEntryDefault,
+				Title:   c.Branding.StartString,
+				Kernel:  kernel,
+				Options: joinCmdline("root=live:CDLABEL="+c.LiveOS.Label, c.Boot.Cmdline),
+			},
+		},
+	}
+	if err := tmpl.Execute(ioutil.Discard, tmplData); err != nil {
+		return &config.KeyError{Key: "isolinux.template", Message: err.Error()}
+	}
+	return nil
+}
+
 // GetSpecialFile will return the special paths for isolinux
 func (s *SyslinuxLoader) GetSpecialFile(t FileType) string {
 	// Currently we're only ever invoked as Legacy|ISO
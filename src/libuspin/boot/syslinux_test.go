@@ -0,0 +1,46 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package boot
+
+import (
+	"libuspin/config"
+	"testing"
+)
+
+const themedSpin = "../../../testdata/themed.spin"
+
+func TestValidateIsolinuxTemplate(t *testing.T) {
+	c, err := config.New(themedSpin)
+	if err != nil {
+		t.Fatalf("Cannot load config: %v", err)
+	}
+	if err := ValidateIsolinuxTemplate(c); err != nil {
+		t.Fatalf("Valid template failed to execute: %v", err)
+	}
+
+	for _, source := range []string{
+		"label {{.Kernel.Missing}}",
+		"{{range .Entries}}label {{.Label}}{{end}}",
+		"{{(index .BootEntries 0).Kernel.TargetPath}} {{.NoSuchField}}",
+	} {
+		c.Isolinux.TemplateData = source
+		err := ValidateIsolinuxTemplate(c)
+		if ke, ok := err.(*config.KeyError); !ok || ke.Key != "isolinux.template" {
+			t.Fatalf("Template should fail to execute: %v: %v", source, err)
+		}
+	}
+}
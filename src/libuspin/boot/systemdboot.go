@@ -0,0 +1,176 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package boot
+
+import (
+	"errors"
+	"github.com/solus-project/libosdev/disk"
+	"libuspin/config"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// SystemdBootTemplate is used to populate fields in the loader.conf
+type SystemdBootTemplate struct {
+	Title string
+	Entry string // ID of the default entry
+}
+
+var (
+	// DefaultSystemdBootLoaderTemplate is the built-in template for loader.conf
+	DefaultSystemdBootLoaderTemplate = `timeout 5
+default {{.Entry}}
+`
+
+	// DefaultSystemdBootEntryTemplate is the built-in template for each boot
+	// entry, executed with an Entry
+	DefaultSystemdBootEntryTemplate = `title {{.Title}}
+linux /{{.Kernel.TargetPath}}
+initrd /{{.Kernel.TargetInitrd}}
+options {{.Options}}
+`
+)
+
+var (
+	// SystemdBootPaths contains the known locations of the systemd-boot (and
+	// older gummiboot) EFI binaries, searched in both the rootfs and the host.
+	SystemdBootPaths = []string{
+		"/usr/lib/systemd/boot/efi/systemd-bootx64.efi",
+		"/usr/lib64/systemd/boot/efi/systemd-bootx64.efi",
+		"/usr/lib/gummiboot/gummibootx64.efi",
+		"/usr/lib64/gummiboot/gummibootx64.efi",
+	}
+)
+
+// SystemdBootLoader provides systemd-boot (formerly gummiboot) support for
+// UEFI booting of ISOs and raw disks
+type SystemdBootLoader struct {
+	// EFI binary found on the host, if any
+	hostPath string
+
+	// Store the configuration for particulars we need to implement
+	config *config.ImageConfiguration
+
+	loaderTemplate *template.Template
+	entryTemplate  *template.Template
+}
+
+// NewSystemdBootLoader will return a newly created SystemdBootLoader instance
+func NewSystemdBootLoader() *SystemdBootLoader {
+	return &SystemdBootLoader{}
+}
+
+// Init will look for systemd-boot on the host. It is not fatal if we can't
+// find it yet, as we'll also look within the rootfs during installation.
+func (s *SystemdBootLoader) Init(c *config.ImageConfiguration) error {
+	for _, path := range SystemdBootPaths {
+		if _, err := os.Stat(path); err == nil {
+			s.hostPath = path
+			break
+		}
+	}
+	tmpl, err := template.New("loader.conf").Parse(DefaultSystemdBootLoaderTemplate)
+	if err != nil {
+		return err
+	}
+	s.loaderTemplate = tmpl
+	if tmpl, err = template.New("entry").Parse(DefaultSystemdBootEntryTemplate); err != nil {
+		return err
+	}
+	s.entryTemplate = tmpl
+	s.config = c
+	return nil
+}
+
+// GetCapabilities will return UEFI support for ISOs and raw disks
+func (s *SystemdBootLoader) GetCapabilities() Capability {
+	return CapInstallUEFI | CapInstallISO | CapInstallRaw
+}
+
+// locateBinary prefers the systemd-boot from the rootfs so that the live
+// media matches the installed system, falling back to the host's copy.
+func (s *SystemdBootLoader) locateBinary(c ConfigurationSource) (string, error) {
+	for _, path := range SystemdBootPaths {
+		rpath := c.JoinRootPath(path)
+		if _, err := os.Stat(rpath); err == nil {
+			return rpath, nil
+		}
+	}
+	if s.hostPath != "" {
+		return s.hostPath, nil
+	}
+	return "", errors.New("Cannot find systemd-boot EFI binary in rootfs or host")
+}
+
+// Install will copy systemd-boot into the EFI tree and write the loader
+// configuration and boot entry
+func (s *SystemdBootLoader) Install(op Capability, c ConfigurationSource) error {
+	if op&CapInstallUEFI != CapInstallUEFI {
+		return errors.New("systemd-boot can only be installed for UEFI")
+	}
+
+	efiBinary, err := s.locateBinary(c)
+	if err != nil {
+		return err
+	}
+
+	// Fallback path for removable media, and the usual path for raw disks
+	targets := []string{c.JoinDeployPath("EFI", "Boot", "BOOTX64.EFI")}
+	if op&CapInstallRaw == CapInstallRaw {
+		targets = append(targets, c.JoinDeployPath("EFI", "systemd", "systemd-bootx64.efi"))
+	}
+	for _, target := range targets {
+		if err := os.MkdirAll(filepath.Dir(target), 00755); err != nil {
+			return err
+		}
+		if err := disk.CopyFile(efiBinary, target); err != nil {
+			return err
+		}
+	}
+
+	entries, err := GetEntries(s.config, op, c)
+	if err != nil {
+		return err
+	}
+
+	tmplData := SystemdBootTemplate{
+		Title: s.config.Branding.Title,
+		Entry: entries[0].ID,
+	}
+	if err := writeTemplate(s.loaderTemplate, c.JoinDeployPath("loader", "loader.conf"), tmplData); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		path := c.JoinDeployPath("loader", "entries", entry.ID+".conf")
+		if err := writeTemplate(s.entryTemplate, path, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetSpecialFile will return the path of the efi.img for ISOs
+func (s *SystemdBootLoader) GetSpecialFile(t FileType) string {
+	switch t {
+	case FileTypeBootEFIImage:
+		return "efi.img"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,174 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package boot
+
+import (
+	"io/ioutil"
+	"libuspin/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testSource is a ConfigurationSource backed by temporary directories
+type testSource struct {
+	root    string
+	deploy  string
+	kernels []*Kernel
+}
+
+func (s *testSource) JoinRootPath(paths ...string) string {
+	return filepath.Join(append([]string{s.root}, paths...)...)
+}
+
+func (s *testSource) JoinDeployPath(paths ...string) string {
+	return filepath.Join(append([]string{s.deploy}, paths...)...)
+}
+
+func (s *testSource) GetRootDevice() string { return "SOLUS" }
+func (s *testSource) GetBootDevice() string { return "" }
+func (s *testSource) GetKernel() *Kernel    { return s.kernels[0] }
+func (s *testSource) GetKernels() []*Kernel { return s.kernels }
+
+// newTestSource will create the temporary root and deploy directories
+func newTestSource(t *testing.T, kernels ...string) *testSource {
+	root, err := ioutil.TempDir("", "uspin-boot-root")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %v", err)
+	}
+	deploy, err := ioutil.TempDir("", "uspin-boot-deploy")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %v", err)
+	}
+	s := &testSource{root: root, deploy: deploy}
+	for _, version := range kernels {
+		s.kernels = append(s.kernels, &Kernel{
+			Version:      version,
+			TargetPath:   "kernel-" + version,
+			TargetInitrd: "initrd-" + version,
+		})
+	}
+	return s
+}
+
+func (s *testSource) Close() {
+	os.RemoveAll(s.root)
+	os.RemoveAll(s.deploy)
+}
+
+func readTestFile(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Cannot read file: %v", err)
+	}
+	return string(data)
+}
+
+func TestSystemdBootInstall(t *testing.T) {
+	tests := []struct {
+		kernels []string
+		entries []config.SectionBootEntry
+		files   map[string]string
+	}{
+		{
+			[]string{"4.8.12-11.current"},
+			nil,
+			map[string]string{
+				"loader.conf": "timeout 5\ndefault live\n",
+				"entries/live.conf": "title Start Solus\n" +
+					"linux /kernel-4.8.12-11.current\n" +
+					"initrd /initrd-4.8.12-11.current\n" +
+					"options root=live:CDLABEL=SOLUS quiet\n",
+			},
+		},
+		{
+			[]string{"4.4.38-30.lts", "4.8.12-11.current"},
+			[]config.SectionBootEntry{
+				{Name: "safe-graphics", Title: "Safe graphics", Options: "nomodeset"},
+				{Name: "debug", Title: "Debug", Cmdline: "debug"},
+			},
+			map[string]string{
+				"loader.conf": "timeout 5\ndefault live\n",
+				"entries/live.conf": "title Start Solus\n" +
+					"linux /kernel-4.4.38-30.lts\n" +
+					"initrd /initrd-4.4.38-30.lts\n" +
+					"options root=live:CDLABEL=SOLUS quiet\n",
+				"entries/safe-graphics.conf": "title Safe graphics\n" +
+					"linux /kernel-4.4.38-30.lts\n" +
+					"initrd /initrd-4.4.38-30.lts\n" +
+					"options root=live:CDLABEL=SOLUS quiet nomodeset\n",
+				"entries/debug.conf": "title Debug\n" +
+					"linux /kernel-4.4.38-30.lts\n" +
+					"initrd /initrd-4.4.38-30.lts\n" +
+					"options root=live:CDLABEL=SOLUS debug\n",
+				"entries/kernel-4.8.12-11.current.conf": "title Start Solus (4.8.12-11.current)\n" +
+					"linux /kernel-4.8.12-11.current\n" +
+					"initrd /initrd-4.8.12-11.current\n" +
+					"options root=live:CDLABEL=SOLUS quiet\n",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		s := newTestSource(t, test.kernels...)
+		defer s.Close()
+
+		binary := s.JoinRootPath(SystemdBootPaths[0])
+		if err := os.MkdirAll(filepath.Dir(binary), 00755); err != nil {
+			t.Fatalf("Cannot create directory: %v", err)
+		}
+		if err := ioutil.WriteFile(binary, []byte("EFI"), 00644); err != nil {
+			t.Fatalf("Cannot write file: %v", err)
+		}
+
+		c := &config.ImageConfiguration{}
+		c.Branding.StartString = "Start Solus"
+		c.Boot.Cmdline = "quiet"
+		c.Boot.Entries = test.entries
+
+		loader := NewSystemdBootLoader()
+		if err := loader.Init(c); err != nil {
+			t.Fatalf("Failed to init systemd-boot: %v", err)
+		}
+		if err := loader.Install(CapInstallUEFI|CapInstallISO, s); err != nil {
+			t.Fatalf("Failed to install systemd-boot: %v", err)
+		}
+
+		entries, err := ioutil.ReadDir(s.JoinDeployPath("loader", "entries"))
+		if err != nil {
+			t.Fatalf("Cannot read entries: %v", err)
+		}
+		if len(entries) != len(test.files)-1 {
+			t.Fatalf("Wrong number of entries: %v", len(entries))
+		}
+		for name, expected := range test.files {
+			if got := readTestFile(t, s.JoinDeployPath("loader", name)); got != expected {
+				t.Fatalf("Wrong contents of %v:\n%v\nexpected:\n%v", name, got, expected)
+			}
+		}
+	}
+
+	s := newTestSource(t, "4.8.12-11.current")
+	defer s.Close()
+	loader := NewSystemdBootLoader()
+	if err := loader.Init(&config.ImageConfiguration{}); err != nil {
+		t.Fatalf("Failed to init systemd-boot: %v", err)
+	}
+	if err := loader.Install(CapInstallLegacy|CapInstallISO, s); err == nil {
+		t.Fatalf("systemd-boot should not install for legacy boot")
+	}
+}
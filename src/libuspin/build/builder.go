@@ -30,12 +30,13 @@ type Builder interface {
 	Init(img *libuspin.ImageSpec) error
 
 	// PrepareWorkspace will attempt to do all prework for setting up the image
-	// deployment areas and such.
-	PrepareWorkspace() error
+	// deployment areas and such, within the given workspace directory.
+	PrepareWorkspace(workspace string) error
 
 	// CreateStorage is used by implementations to create any initial backing storage
 	// they will require, i.e. the place where we install packages to. No processes
-	// should be spawned within it, nor should it be mounted, at this point.
+	// should be spawned within it, nor should it be mounted, at this point. Any
+	// existing storage from a previous attempt must be replaced with empty storage.
 	CreateStorage() error
 
 	// MountStorage should be used by the implementation if it needs to do any mounting
@@ -66,11 +67,26 @@ type Builder interface {
 	Cleanup()
 }
 
+// A Resumable builder can restore the state gathered by CollectAssets when a
+// resumed build skips it, with the storage mounted exactly as it would be for
+// CollectAssets. Builders that are not Resumable simply collect again.
+type Resumable interface {
+	ResumeAssets() error
+}
+
 // NewBuilder will try to return a builder for the given type
 func NewBuilder(name config.ImageType) (Builder, error) {
 	switch name {
 	case config.ImageTypeLiveOS:
 		return NewLiveOSBuilder(), nil
+	case config.ImageTypeRaw:
+		return NewRawBuilder(), nil
+	case config.ImageTypeFlat:
+		return NewFlatBuilder(), nil
+	case config.ImageTypeRootfs:
+		return NewRootfsBuilder(), nil
+	case config.ImageTypeOCI:
+		return NewOCIBuilder(), nil
 	default:
 		return nil, fmt.Errorf("Unknown builder: %v", name)
 	}
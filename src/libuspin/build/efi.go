@@ -0,0 +1,112 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"fmt"
+	"github.com/solus-project/libosdev/commands"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var (
+	efiBinaries []string
+)
+
+func init() {
+	efiBinaries = []string{
+		"mkfs.vfat",
+		"mcopy",
+	}
+}
+
+// An efiSource wraps a LiveOSBuilder so that UEFI loaders install into the
+// EFI staging tree, which becomes the root of efi.img, rather than the ISO.
+type efiSource struct {
+	*LiveOSBuilder
+	stagingDir string
+}
+
+// JoinDeployPath will return a path within the EFI staging tree
+func (e *efiSource) JoinDeployPath(paths ...string) string {
+	return filepath.Join(e.stagingDir, filepath.Join(paths...))
+}
+
+// dirSize returns the total size in bytes of all files under root
+func dirSize(root string) (int64, error) {
+	var size int64
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// efiImageSize returns the size in KiB of a FAT image able to hold size bytes
+// of files, leaving headroom for FAT metadata and cluster slack.
+func efiImageSize(size int64) int64 {
+	return (size/1024)*11/10 + 2048
+}
+
+// efiBootArgs returns the xorriso arguments to add the efi.img at the given
+// path within the ISO as an alternative El Torito boot image for UEFI.
+func efiBootArgs(efiImage string) []string {
+	return []string{
+		"-eltorito-alt-boot",
+		"-e",
+		efiImage,
+		"-no-emul-boot",
+		"-isohybrid-gpt-basdat",
+	}
+}
+
+// createEFIImage will create a FAT image at output containing the entire tree
+// at sourceDir. mtools is used to populate it so that no mounting is needed.
+func createEFIImage(sourceDir, output string) error {
+	size, err := dirSize(sourceDir)
+	if err != nil {
+		return err
+	}
+	sizeKB := efiImageSize(size)
+
+	if err := os.MkdirAll(filepath.Dir(output), 00755); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(output); err != nil {
+		return err
+	}
+	if err := commands.ExecStdoutArgs("mkfs.vfat", []string{"-n", "EFIBOOT", "-C", output, fmt.Sprintf("%d", sizeKB)}); err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(sourceDir)
+	if err != nil {
+		return err
+	}
+	args := []string{"-s", "-i", output}
+	for _, entry := range entries {
+		args = append(args, filepath.Join(sourceDir, entry.Name()))
+	}
+	args = append(args, "::/")
+	return commands.ExecStdoutArgs("mcopy", args)
+}
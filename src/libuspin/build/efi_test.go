@@ -0,0 +1,89 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"io/ioutil"
+	"libuspin/boot"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEFIImageSize(t *testing.T) {
+	tests := []struct {
+		size     int64
+		expected int64
+	}{
+		{0, 2048},
+		{1024 * 1024, 1126 + 2048},
+		{30 * 1024 * 1024, 33792 + 2048},
+	}
+	for _, test := range tests {
+		sizeKB := efiImageSize(test.size)
+		if sizeKB != test.expected {
+			t.Fatalf("efiImageSize(%v) = %v, expected %v", test.size, sizeKB, test.expected)
+		}
+		if sizeKB*1024 <= test.size {
+			t.Fatalf("efi.img of %vKiB cannot hold %v bytes", sizeKB, test.size)
+		}
+	}
+}
+
+func TestEFIStaging(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-efi")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	l := NewLiveOSBuilder()
+	if err := l.PrepareWorkspace(dir); err != nil {
+		t.Fatalf("Cannot prepare workspace: %v", err)
+	}
+	l.kernels = []*boot.Kernel{
+		{TargetPath: "boot/kernel", TargetInitrd: "boot/initrd.img"},
+		{TargetPath: "lts/kernel", TargetInitrd: "lts/initrd.img"},
+	}
+	if err := l.stageEFIAssets(); err != nil {
+		t.Fatalf("Cannot stage EFI assets: %v", err)
+	}
+
+	// Boot assets keep their ISO relative paths within efi.img
+	for _, sub := range []string{"boot", "lts"} {
+		if st, err := os.Stat(filepath.Join(dir, "efi", sub)); err != nil || !st.IsDir() {
+			t.Fatalf("Missing %v in EFI staging tree: %v", sub, err)
+		}
+	}
+
+	// UEFI loaders install into the staging tree, not the ISO
+	src := &efiSource{LiveOSBuilder: l, stagingDir: l.efiStagingDir}
+	if p := src.JoinDeployPath("EFI", "Boot"); p != filepath.Join(dir, "efi", "EFI", "Boot") {
+		t.Fatalf("Wrong EFI deploy path: %v", p)
+	}
+	if p := l.JoinDeployPath("efi.img"); p != filepath.Join(dir, "deploy", "efi.img") {
+		t.Fatalf("Wrong efi.img path within the ISO: %v", p)
+	}
+}
+
+func TestEFIBootArgs(t *testing.T) {
+	args := strings.Join(efiBootArgs("efi.img"), " ")
+	if args != "-eltorito-alt-boot -e efi.img -no-emul-boot -isohybrid-gpt-basdat" {
+		t.Fatalf("Wrong El Torito arguments for efi.img: %v", args)
+	}
+}
@@ -0,0 +1,179 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/solus-project/libosdev/commands"
+	"github.com/solus-project/libosdev/disk"
+	"libuspin"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	shrinkBinaries []string
+)
+
+func init() {
+	shrinkBinaries = []string{
+		"dumpe2fs",
+		"resize2fs",
+	}
+}
+
+// A FlatBuilder is responsible for building unpartitioned filesystem images,
+// i.e. an ext4 loopback image to be used as an evobuild chroot base.
+type FlatBuilder struct {
+	img          *libuspin.ImageSpec
+	rootfsImg    string
+	rootfsDir    string
+	rootfsFormat string
+	rootfsSize   int
+	workspace    string
+}
+
+// NewFlatBuilder should only be used by builder.go
+func NewFlatBuilder() *FlatBuilder {
+	return &FlatBuilder{}
+}
+
+// Init will initialise a FlatBuilder from the given spec
+func (f *FlatBuilder) Init(img *libuspin.ImageSpec) error {
+	f.img = img
+
+	f.rootfsFormat = f.img.Config.Flat.RootfsFormat
+	f.rootfsSize = f.img.Config.Flat.RootfsSize
+
+	if f.img.Config.Flat.Shrink {
+		return checkBinaries(shrinkBinaries)
+	}
+	return nil
+}
+
+// JoinPath is a helper to join paths onto our root workspace directory
+func (f *FlatBuilder) JoinPath(paths ...string) string {
+	return filepath.Join(f.workspace, filepath.Join(paths...))
+}
+
+// PrepareWorkspace sets up the required directories for the FlatBuilder
+func (f *FlatBuilder) PrepareWorkspace(workspace string) error {
+	f.workspace = workspace
+
+	f.rootfsDir = f.JoinPath("rootfs")
+	f.rootfsImg = f.JoinPath("rootfs.img")
+
+	return createDirs(f.workspace, f.rootfsDir)
+}
+
+// CreateStorage will create the rootfs.img that becomes the final image
+func (f *FlatBuilder) CreateStorage() error {
+	if err := disk.CreateSparseFile(f.rootfsImg, f.rootfsSize); err != nil {
+		return err
+	}
+	return disk.FormatAs(f.rootfsImg, f.rootfsFormat)
+}
+
+// MountStorage will mount the rootfs.img so that the package manager can
+// take over
+func (f *FlatBuilder) MountStorage() error {
+	return disk.GetMountManager().Mount(f.rootfsImg, f.rootfsDir, f.rootfsFormat, "loop")
+}
+
+// CollectAssets has nothing to do for flat images, as they are never booted
+// directly and need neither a kernel nor a bootloader.
+func (f *FlatBuilder) CollectAssets() error {
+	return nil
+}
+
+// UnmountStorage will unmount the rootfs.img and check the filesystem, then
+// shrink it if requested.
+func (f *FlatBuilder) UnmountStorage() error {
+	if err := disk.GetMountManager().Unmount(f.rootfsDir); err != nil {
+		return err
+	}
+	if err := disk.CheckFS(f.rootfsImg, f.rootfsFormat); err != nil {
+		return err
+	}
+	if !f.img.Config.Flat.Shrink {
+		return nil
+	}
+	return f.shrink()
+}
+
+// shrink will resize the filesystem to the minimum size and then truncate
+// the image file to match.
+func (f *FlatBuilder) shrink() error {
+	if err := commands.ExecStdoutArgs("resize2fs", []string{"-M", f.rootfsImg}); err != nil {
+		return err
+	}
+
+	out, err := exec.Command("dumpe2fs", "-h", f.rootfsImg).Output()
+	if err != nil {
+		return err
+	}
+
+	var blockCount, blockSize int64
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		fields := strings.SplitN(sc.Text(), ":", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(fields[1])
+		switch strings.TrimSpace(fields[0]) {
+		case "Block count":
+			blockCount, err = strconv.ParseInt(value, 10, 64)
+		case "Block size":
+			blockSize, err = strconv.ParseInt(value, 10, 64)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if blockCount == 0 || blockSize == 0 {
+		return fmt.Errorf("Unable to determine filesystem size of %v", f.rootfsImg)
+	}
+
+	newSize := blockCount * blockSize
+	log.WithFields(log.Fields{
+		"size": newSize,
+	}).Info("Shrinking image")
+	return os.Truncate(f.rootfsImg, newSize)
+}
+
+// FinalizeImage will move the completed rootfs.img into place
+func (f *FlatBuilder) FinalizeImage() error {
+	return emitImage(f.rootfsImg, f.img.Config.Flat.FileName)
+}
+
+// GetRootDir returns the path to the mounted rootfs.img
+func (f *FlatBuilder) GetRootDir() string {
+	return f.rootfsDir
+}
+
+// Cleanup will ensure the rootfs.img is unmounted
+func (f *FlatBuilder) Cleanup() {
+	log.Info("Cleaning up")
+	disk.GetMountManager().UnmountAll()
+}
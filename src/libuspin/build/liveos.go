@@ -24,7 +24,6 @@ import (
 	"libuspin"
 	"libuspin/boot"
 	"os"
-	"os/exec"
 	"path/filepath"
 )
 
@@ -59,15 +58,17 @@ type LiveOSBuilder struct {
 	deployDir      string
 	liveosDir      string
 	liveStagingDir string
+	efiStagingDir  string
 	workspace      string
 
 	cdlabel string // What to name the ISO
 
 	// For storing bootloader bits
 	loaders []boot.Loader
+	uefi    bool // Whether we have a loader that can boot the ISO via UEFI
 
-	// The kernel to be used for booting
-	kernel *boot.Kernel
+	// The kernels to be used for booting, the default being first
+	kernels []*boot.Kernel
 }
 
 // NewLiveOSBuilder should only be used by builder.go
@@ -80,10 +81,8 @@ func (l *LiveOSBuilder) Init(img *libuspin.ImageSpec) error {
 	l.img = img
 
 	// Ensure all required binaries are available before we go doing anything.
-	for _, bin := range requiredBinaries {
-		if _, err := exec.LookPath(bin); err != nil {
-			return err
-		}
+	if err := checkBinaries(requiredBinaries); err != nil {
+		return err
 	}
 
 	// rootfs.img particulars
@@ -103,6 +102,14 @@ func (l *LiveOSBuilder) Init(img *libuspin.ImageSpec) error {
 		return errors.New("No usable bootloader found. Need ISO|Legacy")
 	}
 
+	// UEFI support is optional, and only enabled with a UEFI|ISO loader
+	if boot.HaveLoaderWithMask(l.loaders, boot.CapInstallISO|boot.CapInstallUEFI) {
+		if err := checkBinaries(efiBinaries); err != nil {
+			return err
+		}
+		l.uefi = true
+	}
+
 	return nil
 }
 
@@ -112,20 +119,8 @@ func (l *LiveOSBuilder) JoinPath(paths ...string) string {
 }
 
 // PrepareWorkspace sets up the required directories for the LiveOSBuilder
-func (l *LiveOSBuilder) PrepareWorkspace() error {
-	var err error
-	if l.workspace, err = filepath.Abs("./workspace"); err != nil {
-		return err
-	}
-
-	// Purge existing workspace always
-	if st, err := os.Stat(l.workspace); err == nil {
-		if st != nil && st.Mode().IsDir() {
-			if err = os.RemoveAll(l.workspace); err != nil {
-				return err
-			}
-		}
-	}
+func (l *LiveOSBuilder) PrepareWorkspace(workspace string) error {
+	l.workspace = workspace
 
 	// Initialise our base variables
 	l.rootfsDir = l.JoinPath("rootfs")
@@ -134,25 +129,19 @@ func (l *LiveOSBuilder) PrepareWorkspace() error {
 	l.liveosDir = l.JoinPath("deploy", "LiveOS")
 	// Inside the workspace only
 	l.liveStagingDir = l.JoinPath("LiveOS")
+	// Root of the efi.img
+	l.efiStagingDir = l.JoinPath("efi")
 	l.rootfsImg = l.JoinPath("LiveOS", "rootfs.img")
 
 	// As and when we add new directories, populate them here
-	requiredDirs := []string{
+	return createDirs(
 		l.workspace,
 		l.rootfsDir,
 		l.deployDir,
 		l.liveosDir,
 		l.liveStagingDir,
-	}
-
-	// Create all required directories
-	for _, dir := range requiredDirs {
-		if err = os.MkdirAll(dir, 00755); err != nil {
-			return err
-		}
-	}
-
-	return nil
+		l.efiStagingDir,
+	)
 }
 
 // CreateStorage will create the rootfs.img in which we will contain the
@@ -196,7 +185,6 @@ func (l *LiveOSBuilder) GetRootDir() string {
 
 // The very last call in the chain, we seal the deal by spinning the ISO
 func (l *LiveOSBuilder) spinISO() error {
-	uefi := false
 	// Get absolute path for "./${name}"
 	outputFilename := l.img.Config.LiveOS.FileName
 	if o, err := filepath.Abs(outputFilename); err == nil {
@@ -224,6 +212,7 @@ func (l *LiveOSBuilder) spinISO() error {
 	bootbinFile := bloader.GetSpecialFile(boot.FileTypeBootElToritoBinary)
 	bootcatFile := bloader.GetSpecialFile(boot.FileTypeBootElToritoCatalog)
 	mbrFile := bloader.GetSpecialFile(boot.FileTypeBootMBR)
+	grubMbrFile := bloader.GetSpecialFile(boot.FileTypeBootGrub2MBR)
 
 	// This is where we'd install syslinux or other loader..
 	// Note we'll need to do investigation for GRUB to determine precisely how to
@@ -246,17 +235,18 @@ func (l *LiveOSBuilder) spinISO() error {
 			"-isohybrid-mbr",
 			mbrFile,
 		}...)
-	}
-	// Still unused for now
-	if uefi {
+	} else if grubMbrFile != "" {
 		command = append(command, []string{
-			"-eltorito-alt-boot",
-			"-e",
-			"efi.img", // TODO: Use appropriate origin
-			"-no-emul-boot",
-			"-isohybrid-gpt-basdat",
+			"--grub2-mbr",
+			grubMbrFile,
+			"--grub2-boot-info",
 		}...)
 	}
+	// Add the efi.img as an alternative boot image for UEFI machines
+	if l.uefi {
+		efiLoader := boot.GetLoaderWithMask(l.loaders, boot.CapInstallISO|boot.CapInstallUEFI)
+		command = append(command, efiBootArgs(efiLoader.GetSpecialFile(boot.FileTypeBootEFIImage))...)
+	}
 	// Set the output filename and directory
 	command = append(command, []string{
 		"-output",
@@ -275,14 +265,59 @@ func (l *LiveOSBuilder) installBootloader() error {
 	return bloader.Install(caps, l)
 }
 
-// CollectAssets will collect the kernel and create a new initramfs to be used
-// during the boot process
+// stageEFIAssets will copy the kernels and initrds into the EFI staging tree.
+// The EFI loader can only see its own FAT filesystem, so needs its own copy
+// of the boot assets at the same relative paths as on the ISO.
+func (l *LiveOSBuilder) stageEFIAssets() error {
+	for _, kernel := range l.kernels {
+		for _, asset := range []string{kernel.TargetPath, kernel.TargetInitrd} {
+			target := filepath.Join(l.efiStagingDir, asset)
+			if err := os.MkdirAll(filepath.Dir(target), 00755); err != nil {
+				return err
+			}
+			if err := disk.CopyFile(l.JoinDeployPath(asset), target); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Install the UEFI bootloader into the EFI staging tree, along with the
+// kernel and initrd, and then build the efi.img from it.
+func (l *LiveOSBuilder) installUEFIBootloader() error {
+	caps := boot.CapInstallISO | boot.CapInstallUEFI
+	bloader := boot.GetLoaderWithMask(l.loaders, caps)
+
+	efiImage := bloader.GetSpecialFile(boot.FileTypeBootEFIImage)
+	if efiImage == "" {
+		return errors.New("UEFI bootloader did not provide an efi.img path")
+	}
+
+	if err := l.stageEFIAssets(); err != nil {
+		return err
+	}
+
+	src := &efiSource{
+		LiveOSBuilder: l,
+		stagingDir:    l.efiStagingDir,
+	}
+	if err := bloader.Install(caps, src); err != nil {
+		return err
+	}
+
+	return createEFIImage(l.efiStagingDir, l.JoinDeployPath(efiImage))
+}
+
+// CollectAssets will collect the kernels and create a new initramfs for each
+// to be used during the boot process
 func (l *LiveOSBuilder) CollectAssets() error {
-	kernel, err := boot.GetKernelFromRoot(l.rootfsDir)
+	bootConf := &l.img.Config.Boot
+	kernels, err := boot.SelectKernels(l.rootfsDir, bootConf.Kernel, bootConf.Kernels)
 	if err != nil {
 		return err
 	}
-	l.kernel = kernel
+	l.kernels = kernels
 
 	// Create the boot/ directory
 	bootbase := l.img.Config.LiveOS.BootDir
@@ -291,18 +326,58 @@ func (l *LiveOSBuilder) CollectAssets() error {
 		return err
 	}
 
-	// Copy the kernel, standard "kernel" name
-	ktgt := filepath.Join(bootdir, "kernel")
-	if err := disk.CopyFile(kernel.Path, ktgt); err != nil {
+	for i, kernel := range kernels {
+		kname, iname := kernelAssetNames(i, kernel)
+		if err := l.collectKernel(kernel, bootbase, kname, iname); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// kernelAssetNames returns the names of the kernel and initrd within the boot
+// directory for the i'th selected kernel
+func kernelAssetNames(i int, kernel *boot.Kernel) (string, string) {
+	// Default kernel retains the standard "kernel" name
+	if i == 0 {
+		return "kernel", "initrd.img"
+	}
+	return "kernel-" + kernel.Version, "initrd-" + kernel.Version + ".img"
+}
+
+// ResumeAssets will select the kernels again for a resumed build, as the
+// kernels and initrds collected by the earlier build are already deployed.
+func (l *LiveOSBuilder) ResumeAssets() error {
+	bootConf := &l.img.Config.Boot
+	kernels, err := boot.SelectKernels(l.rootfsDir, bootConf.Kernel, bootConf.Kernels)
+	if err != nil {
+		return err
+	}
+	bootbase := l.img.Config.LiveOS.BootDir
+	for i, kernel := range kernels {
+		kname, iname := kernelAssetNames(i, kernel)
+		kernel.TargetPath = filepath.Join(bootbase, kname)
+		kernel.TargetInitrd = filepath.Join(bootbase, iname)
+	}
+	l.kernels = kernels
+	return nil
+}
+
+// collectKernel will copy the kernel into the boot directory and build its
+// initrd alongside it
+func (l *LiveOSBuilder) collectKernel(kernel *boot.Kernel, bootbase, kname, iname string) error {
+	bootdir := l.JoinDeployPath(bootbase)
+
+	if err := disk.CopyFile(kernel.Path, filepath.Join(bootdir, kname)); err != nil {
 		return err
 	}
 
 	// Required by the bootloaders
-	l.kernel.TargetPath = filepath.Join(bootbase, "kernel")
-	l.kernel.TargetInitrd = filepath.Join(bootbase, "initrd.img")
+	kernel.TargetPath = filepath.Join(bootbase, kname)
+	kernel.TargetInitrd = filepath.Join(bootbase, iname)
 
 	// Attempt to build dracut image
-	drac := boot.NewDracut(l.kernel)
+	drac := boot.NewDracut(kernel)
 	drac.Modules = boot.DracutLiveOSModules
 	drac.Drivers = boot.DracutLiveOSDrivers
 	drac.OutputFilename = "/live.img"
@@ -313,23 +388,23 @@ func (l *LiveOSBuilder) CollectAssets() error {
 
 	// Copy the new live.img asset across
 	dracSource := filepath.Join(l.rootfsDir, "live.img")
-	dracTarget := filepath.Join(bootdir, "initrd.img")
+	dracTarget := filepath.Join(bootdir, iname)
 	if err := disk.CopyFile(dracSource, dracTarget); err != nil {
 		return err
 	}
 
 	// Nuke live.img from the filesystem
-	if err := os.Remove(dracSource); err != nil {
-		return err
-	}
-
-	return nil
+	return os.Remove(dracSource)
 }
 
 // FinalizeImage will go ahead and finish up the ISO construction
 func (l *LiveOSBuilder) FinalizeImage() error {
 	// First up, create the squashfs
 	squash := filepath.Join(l.liveosDir, "squashfs.img")
+	// mksquashfs appends to an existing image, i.e. from a resumed build
+	if err := os.Remove(squash); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	if err := disk.CreateSquashfs(l.liveStagingDir, squash, l.img.Config.LiveOS.Compression); err != nil {
 		return err
 	}
@@ -339,7 +414,12 @@ func (l *LiveOSBuilder) FinalizeImage() error {
 		return err
 	}
 
-	// TODO: Install bootloader, copy asset files, put kernel in place, etc.
+	if l.uefi {
+		if err := l.installUEFIBootloader(); err != nil {
+			return err
+		}
+	}
+
 	return l.spinISO()
 }
 
@@ -367,7 +447,12 @@ func (l *LiveOSBuilder) JoinRootPath(paths ...string) string {
 	return filepath.Join(l.rootfsDir, filepath.Join(paths...))
 }
 
-// GetKernel returns our stored kernel object
+// GetKernel returns our default kernel object
 func (l *LiveOSBuilder) GetKernel() *boot.Kernel {
-	return l.kernel
+	return l.kernels[0]
+}
+
+// GetKernels returns all of our kernel objects
+func (l *LiveOSBuilder) GetKernels() []*boot.Kernel {
+	return l.kernels
 }
@@ -0,0 +1,108 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"libuspin"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// An OCIBuilder installs into a workspace directory exactly like the
+// RootfsBuilder, but emits the tree as a single layer OCI image layout.
+type OCIBuilder struct {
+	*RootfsBuilder
+}
+
+// NewOCIBuilder should only be used by builder.go
+func NewOCIBuilder() *OCIBuilder {
+	return &OCIBuilder{
+		RootfsBuilder: NewRootfsBuilder(),
+	}
+}
+
+// Init will initialise an OCIBuilder from the given spec
+func (o *OCIBuilder) Init(img *libuspin.ImageSpec) error {
+	o.img = img
+	return nil
+}
+
+// FinalizeImage will write the OCI image layout and then read it back to
+// ensure it is complete and consistent.
+func (o *OCIBuilder) FinalizeImage() error {
+	conf := &o.img.Config.OCI
+	layout, err := filepath.Abs(conf.FileName)
+	if err != nil {
+		return err
+	}
+
+	clamp, err := sourceDateEpoch()
+	if err != nil {
+		return err
+	}
+	created := clamp
+	if created.IsZero() {
+		created = time.Now()
+	}
+
+	imageConfig := &ociImageConfig{
+		Created:      created.UTC(),
+		Architecture: conf.Architecture,
+		OS:           "linux",
+		Config: ociRuntimeConfig{
+			Entrypoint: conf.Entrypoint,
+			Cmd:        conf.Cmd,
+			Env:        conf.Env,
+			WorkingDir: conf.WorkingDir,
+			Labels:     conf.Labels,
+		},
+	}
+
+	// Write into a fresh directory beside the target so that we never
+	// accumulate blobs, and only replace the target once verified
+	tmp, err := ioutil.TempDir(filepath.Dir(layout), "."+filepath.Base(layout)+"-")
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp, 00755); err == nil {
+		err = o.writeLayout(tmp, imageConfig, clamp)
+	}
+	if err == nil {
+		err = ReplaceOCILayout(tmp, layout)
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+	}
+	return err
+}
+
+// writeLayout will write and verify the image layout at the given directory
+func (o *OCIBuilder) writeLayout(layout string, imageConfig *ociImageConfig, clamp time.Time) error {
+	conf := &o.img.Config.OCI
+	if err := WriteOCILayout(layout, o.rootfsDir, conf.Tag, imageConfig, clamp); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"layout": layout,
+		"tag":    conf.Tag,
+	}).Info("Verifying OCI image layout")
+	return VerifyOCILayout(layout)
+}
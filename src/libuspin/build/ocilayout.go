@@ -0,0 +1,348 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// MediaTypeOCIManifest is the media type of an OCI image manifest
+	MediaTypeOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+
+	// MediaTypeOCIConfig is the media type of an OCI image configuration
+	MediaTypeOCIConfig = "application/vnd.oci.image.config.v1+json"
+
+	// MediaTypeOCILayerGzip is the media type of a gzip compressed layer
+	MediaTypeOCILayerGzip = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+	// AnnotationOCIRefName is the annotation used in index.json for the tag
+	AnnotationOCIRefName = "org.opencontainers.image.ref.name"
+
+	ociLayoutVersion = "1.0.0"
+)
+
+// ociDescriptor points to a blob within the image layout
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ociIndex is the index.json at the top of the image layout
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+// ociManifest ties the configuration and layers of an image together
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+// ociRuntimeConfig is the execution configuration of the container
+type ociRuntimeConfig struct {
+	Entrypoint []string          `json:"Entrypoint,omitempty"`
+	Cmd        []string          `json:"Cmd,omitempty"`
+	Env        []string          `json:"Env,omitempty"`
+	WorkingDir string            `json:"WorkingDir,omitempty"`
+	Labels     map[string]string `json:"Labels,omitempty"`
+}
+
+// ociRootfs lists the uncompressed digests of each layer
+type ociRootfs struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// ociImageConfig is the image configuration blob
+type ociImageConfig struct {
+	Created      time.Time        `json:"created"`
+	Architecture string           `json:"architecture"`
+	OS           string           `json:"os"`
+	Config       ociRuntimeConfig `json:"config"`
+	Rootfs       ociRootfs        `json:"rootfs"`
+}
+
+// ociLayoutFile is the oci-layout marker file
+type ociLayoutFile struct {
+	ImageLayoutVersion string `json:"imageLayoutVersion"`
+}
+
+// digestOf returns the OCI digest string for a sha256 sum
+func digestOf(sum []byte) string {
+	return "sha256:" + hex.EncodeToString(sum)
+}
+
+// blobPath returns the path of the blob with the given digest in the layout
+func blobPath(layout, digest string) (string, error) {
+	fields := strings.SplitN(digest, ":", 2)
+	if len(fields) != 2 || fields[0] != "sha256" || fields[1] == "" {
+		return "", fmt.Errorf("Unsupported digest: %v", digest)
+	}
+	return filepath.Join(layout, "blobs", fields[0], fields[1]), nil
+}
+
+// writeJSONBlob will marshal obj into the layout as a blob, returning its
+// descriptor.
+func writeJSONBlob(layout, mediaType string, obj interface{}) (ociDescriptor, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	sum := sha256.Sum256(data)
+	desc := ociDescriptor{
+		MediaType: mediaType,
+		Digest:    digestOf(sum[:]),
+		Size:      int64(len(data)),
+	}
+	path, err := blobPath(layout, desc.Digest)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	return desc, ioutil.WriteFile(path, data, 00644)
+}
+
+// writeLayerBlob will write a gzip compressed tarball of root into the layout,
+// returning the layer descriptor and its uncompressed diff ID.
+func writeLayerBlob(layout, root string, clamp time.Time) (ociDescriptor, string, error) {
+	tmp, err := ioutil.TempFile(filepath.Join(layout, "blobs", "sha256"), ".layer")
+	if err != nil {
+		return ociDescriptor{}, "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	compressedSum := sha256.New()
+	diffSum := sha256.New()
+	gz := gzip.NewWriter(io.MultiWriter(tmp, compressedSum))
+	if err := WriteTarball(io.MultiWriter(gz, diffSum), root, clamp); err != nil {
+		return ociDescriptor{}, "", err
+	}
+	if err := gz.Close(); err != nil {
+		return ociDescriptor{}, "", err
+	}
+	st, err := tmp.Stat()
+	if err != nil {
+		return ociDescriptor{}, "", err
+	}
+
+	desc := ociDescriptor{
+		MediaType: MediaTypeOCILayerGzip,
+		Digest:    digestOf(compressedSum.Sum(nil)),
+		Size:      st.Size(),
+	}
+	path, err := blobPath(layout, desc.Digest)
+	if err != nil {
+		return ociDescriptor{}, "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return ociDescriptor{}, "", err
+	}
+	return desc, digestOf(diffSum.Sum(nil)), nil
+}
+
+// WriteOCILayout will write root as a single layer OCI image layout at the
+// given directory, with the given image configuration and reference name.
+func WriteOCILayout(layout, root, refName string, config *ociImageConfig, clamp time.Time) error {
+	if err := createDirs(filepath.Join(layout, "blobs", "sha256")); err != nil {
+		return err
+	}
+
+	layer, diffID, err := writeLayerBlob(layout, root, clamp)
+	if err != nil {
+		return err
+	}
+	config.Rootfs = ociRootfs{
+		Type:    "layers",
+		DiffIDs: []string{diffID},
+	}
+	configDesc, err := writeJSONBlob(layout, MediaTypeOCIConfig, config)
+	if err != nil {
+		return err
+	}
+
+	manifest := &ociManifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		Config:        configDesc,
+		Layers:        []ociDescriptor{layer},
+	}
+	manifestDesc, err := writeJSONBlob(layout, MediaTypeOCIManifest, manifest)
+	if err != nil {
+		return err
+	}
+	manifestDesc.Annotations = map[string]string{
+		AnnotationOCIRefName: refName,
+	}
+
+	index := &ociIndex{
+		SchemaVersion: 2,
+		Manifests:     []ociDescriptor{manifestDesc},
+	}
+	if err := writeJSONFile(filepath.Join(layout, "index.json"), index); err != nil {
+		return err
+	}
+	return writeJSONFile(filepath.Join(layout, "oci-layout"), &ociLayoutFile{ociLayoutVersion})
+}
+
+// ociLayoutEntries are the only entries found at the top of an image layout
+// written by WriteOCILayout.
+var ociLayoutEntries = map[string]bool{
+	"oci-layout": true,
+	"index.json": true,
+	"blobs":      true,
+}
+
+// ReplaceOCILayout will move the layout at src to dest. An existing dest is
+// only removed if it is empty or is clearly an old image layout, containing
+// oci-layout and index.json and nothing but blobs besides, so that a mistaken
+// filename can never delete anything else.
+func ReplaceOCILayout(src, dest string) error {
+	entries, err := ioutil.ReadDir(dest)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return os.Rename(src, dest)
+		}
+		return fmt.Errorf("Cannot replace %v: %v", dest, err)
+	}
+	found := 0
+	for _, entry := range entries {
+		if !ociLayoutEntries[entry.Name()] {
+			return fmt.Errorf("Refusing to replace %v: not an OCI image layout, found '%v'", dest, entry.Name())
+		}
+		if entry.Name() != "blobs" {
+			found++
+		}
+	}
+	if len(entries) > 0 && found != 2 {
+		return fmt.Errorf("Refusing to replace %v: not an OCI image layout", dest)
+	}
+	if err := os.RemoveAll(dest); err != nil {
+		return err
+	}
+	return os.Rename(src, dest)
+}
+
+// writeJSONFile marshals obj to the given path
+func writeJSONFile(path string, obj interface{}) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 00644)
+}
+
+// readJSONBlob will verify the blob for desc and unmarshal it into obj
+func readJSONBlob(layout string, desc ociDescriptor, obj interface{}) error {
+	if err := verifyBlob(layout, desc); err != nil {
+		return err
+	}
+	path, _ := blobPath(layout, desc.Digest)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, obj)
+}
+
+// verifyBlob ensures the blob for desc exists with the right size and digest
+func verifyBlob(layout string, desc ociDescriptor) error {
+	path, err := blobPath(layout, desc.Digest)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, fi)
+	if err != nil {
+		return err
+	}
+	if size != desc.Size {
+		return fmt.Errorf("Blob %v has size %v, expected %v", desc.Digest, size, desc.Size)
+	}
+	if digest := digestOf(h.Sum(nil)); digest != desc.Digest {
+		return fmt.Errorf("Blob %v has mismatched digest %v", desc.Digest, digest)
+	}
+	return nil
+}
+
+// VerifyOCILayout will read back an OCI image layout, ensuring that every blob
+// reachable from index.json is present and matches its descriptor.
+func VerifyOCILayout(layout string) error {
+	var marker ociLayoutFile
+	data, err := ioutil.ReadFile(filepath.Join(layout, "oci-layout"))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &marker); err != nil {
+		return err
+	}
+	if marker.ImageLayoutVersion != ociLayoutVersion {
+		return fmt.Errorf("Unsupported image layout version: %v", marker.ImageLayoutVersion)
+	}
+
+	var index ociIndex
+	if data, err = ioutil.ReadFile(filepath.Join(layout, "index.json")); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return err
+	}
+	if len(index.Manifests) == 0 {
+		return fmt.Errorf("No manifests found in %v", layout)
+	}
+
+	for _, mdesc := range index.Manifests {
+		var manifest ociManifest
+		if err := readJSONBlob(layout, mdesc, &manifest); err != nil {
+			return err
+		}
+		var config ociImageConfig
+		if err := readJSONBlob(layout, manifest.Config, &config); err != nil {
+			return err
+		}
+		if len(config.Rootfs.DiffIDs) != len(manifest.Layers) {
+			return fmt.Errorf("Manifest %v has %v layers but %v diff_ids", mdesc.Digest,
+				len(manifest.Layers), len(config.Rootfs.DiffIDs))
+		}
+		for _, layer := range manifest.Layers {
+			if err := verifyBlob(layout, layer); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOCILayout(t *testing.T) {
+	root := makeTestTree(t)
+	defer os.RemoveAll(root)
+
+	layout, err := ioutil.TempDir("", "uspin-oci")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(layout)
+
+	conf := &ociImageConfig{
+		Created:      time.Unix(1000, 0).UTC(),
+		Architecture: "amd64",
+		OS:           "linux",
+		Config: ociRuntimeConfig{
+			Entrypoint: []string{"/bin/sh"},
+			Env:        []string{"PATH=/usr/bin"},
+		},
+	}
+	if err := WriteOCILayout(layout, root, "latest", conf, time.Unix(1000, 0)); err != nil {
+		t.Fatalf("Failed to write OCI layout: %v", err)
+	}
+	if err := VerifyOCILayout(layout); err != nil {
+		t.Fatalf("Failed to verify OCI layout: %v", err)
+	}
+
+	// Now corrupt the layer and ensure we notice
+	blobs, err := filepath.Glob(filepath.Join(layout, "blobs", "sha256", "*"))
+	if err != nil || len(blobs) != 3 {
+		t.Fatalf("Expected 3 blobs in layout, found %v", len(blobs))
+	}
+	for _, blob := range blobs {
+		if err := ioutil.WriteFile(blob, []byte("corrupt"), 00644); err != nil {
+			t.Fatalf("Cannot corrupt blob: %v", err)
+		}
+	}
+	if err := VerifyOCILayout(layout); err == nil {
+		t.Fatalf("Corrupt OCI layout passed verification")
+	}
+}
+
+func TestReplaceOCILayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-oci")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	newLayout := func() string {
+		src, err := ioutil.TempDir(dir, "new")
+		if err != nil {
+			t.Fatalf("Cannot create temporary directory: %v", err)
+		}
+		for _, name := range []string{"oci-layout", "index.json"} {
+			if err := ioutil.WriteFile(filepath.Join(src, name), []byte("{}"), 00644); err != nil {
+				t.Fatalf("Cannot write %v: %v", name, err)
+			}
+		}
+		return src
+	}
+
+	dest := filepath.Join(dir, "image")
+	if err := ReplaceOCILayout(newLayout(), dest); err != nil {
+		t.Fatalf("Failed to move layout into place: %v", err)
+	}
+	if err := createDirs(filepath.Join(dest, "blobs", "sha256")); err != nil {
+		t.Fatalf("Cannot create blobs: %v", err)
+	}
+	if err := ReplaceOCILayout(newLayout(), dest); err != nil {
+		t.Fatalf("Failed to replace an old layout: %v", err)
+	}
+
+	// Anything else must be left alone
+	if err := ioutil.WriteFile(filepath.Join(dest, "precious"), nil, 00644); err != nil {
+		t.Fatalf("Cannot write file: %v", err)
+	}
+	if err := ReplaceOCILayout(newLayout(), dest); err == nil {
+		t.Fatalf("Replaced a directory that is not an OCI layout")
+	}
+	if err := ReplaceOCILayout(newLayout(), dir); err == nil {
+		t.Fatalf("Replaced a parent of the layout")
+	}
+	if _, err := os.Stat(filepath.Join(dest, "precious")); err != nil {
+		t.Fatalf("Unrelated file was removed: %v", err)
+	}
+}
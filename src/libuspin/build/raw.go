@@ -0,0 +1,519 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"bytes"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/solus-project/libosdev/commands"
+	"github.com/solus-project/libosdev/disk"
+	"libuspin"
+	"libuspin/boot"
+	"libuspin/config"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	rawBinaries []string
+
+	// gptPartitionTypes maps our partition types to their GPT type GUID
+	gptPartitionTypes = map[config.PartitionType]string{
+		config.PartitionTypeLinux: "0FC63DAF-8483-4772-8E79-3D69D8477DE4",
+		config.PartitionTypeESP:   "C12A7328-F81F-11D2-BA4B-00A0C93EC93B",
+		config.PartitionTypeSwap:  "0657FD6D-A4AB-43C4-84E5-0933C84B4F4F",
+		config.PartitionTypeBIOS:  "21686148-6449-6E6F-744E-656564454649",
+	}
+
+	// mbrPartitionTypes maps our partition types to their msdos type ID
+	mbrPartitionTypes = map[config.PartitionType]string{
+		config.PartitionTypeLinux: "83",
+		config.PartitionTypeESP:   "ef",
+		config.PartitionTypeSwap:  "82",
+	}
+)
+
+func init() {
+	rawBinaries = []string{
+		"losetup",
+		"sfdisk",
+	}
+}
+
+// A rawPartition tracks the runtime state of a configured partition
+type rawPartition struct {
+	config.SectionPartition
+	device string // i.e. /dev/loop0p1
+}
+
+// byMountDepth sorts partitions so that parent mountpoints come first
+type byMountDepth []*rawPartition
+
+func (b byMountDepth) Len() int      { return len(b) }
+func (b byMountDepth) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byMountDepth) Less(i, j int) bool {
+	return mountDepth(b[i].MountPoint) < mountDepth(b[j].MountPoint)
+}
+
+// mountDepth returns how deeply nested a mountpoint is, with / being 0
+func mountDepth(mountpoint string) int {
+	if mountpoint == "/" {
+		return 0
+	}
+	return strings.Count(mountpoint, "/")
+}
+
+// A RawBuilder is responsible for building partitioned disk images that
+// may be booted directly, i.e. in a VM or after being written to a disk.
+type RawBuilder struct {
+	img        *libuspin.ImageSpec
+	diskImg    string
+	rootfsDir  string
+	workspace  string
+	loopDevice string
+
+	partitions []*rawPartition
+	mounted    []*rawPartition
+
+	// For storing bootloader bits
+	loaders []boot.Loader
+
+	// The kernels to be used for booting, the default being first
+	kernels []*boot.Kernel
+	initrds []string // Host path to the initrd of each kernel
+
+	// Mode of the bootloader currently being installed
+	installMode boot.Capability
+}
+
+// NewRawBuilder should only be used by builder.go
+func NewRawBuilder() *RawBuilder {
+	return &RawBuilder{}
+}
+
+// Init will initialise a RawBuilder from the given spec
+func (r *RawBuilder) Init(img *libuspin.ImageSpec) error {
+	r.img = img
+
+	if err := checkBinaries(rawBinaries); err != nil {
+		return err
+	}
+
+	// Make sure every partition can be formatted before the disk is built
+	var mkfs []string
+	for n := range r.img.Config.Raw.Partitions {
+		p := &rawPartition{
+			SectionPartition: r.img.Config.Raw.Partitions[n],
+		}
+		r.partitions = append(r.partitions, p)
+		if p.Filesystem != "" {
+			mkfs = append(mkfs, formatCommand(p.Filesystem))
+		}
+	}
+	if err := checkBinaries(mkfs); err != nil {
+		return err
+	}
+
+	// Bootloaders are optional for raw disks, i.e. VMs with external kernels
+	loaders, err := boot.InitLoaders(r.img.Config, r.img.Config.Raw.Bootloaders)
+	if err != nil {
+		return err
+	}
+	r.loaders = loaders
+	for n, loader := range r.loaders {
+		if loader.GetCapabilities()&boot.CapInstallRaw != boot.CapInstallRaw {
+			return fmt.Errorf("Bootloader cannot be installed to a raw disk: %v", r.img.Config.Raw.Bootloaders[n])
+		}
+	}
+
+	return nil
+}
+
+// JoinPath is a helper to join paths onto our root workspace directory
+func (r *RawBuilder) JoinPath(paths ...string) string {
+	return filepath.Join(r.workspace, filepath.Join(paths...))
+}
+
+// PrepareWorkspace sets up the required directories for the RawBuilder
+func (r *RawBuilder) PrepareWorkspace(workspace string) error {
+	r.workspace = workspace
+
+	r.rootfsDir = r.JoinPath("rootfs")
+	r.diskImg = r.JoinPath("disk.img")
+
+	return createDirs(r.workspace, r.rootfsDir)
+}
+
+// partitionScript returns the sfdisk input to create our partition layout
+func (r *RawBuilder) partitionScript() string {
+	var buf bytes.Buffer
+	types := gptPartitionTypes
+
+	if r.img.Config.Raw.Table == config.PartitionTableGPT {
+		buf.WriteString("label: gpt\n")
+	} else {
+		buf.WriteString("label: dos\n")
+		types = mbrPartitionTypes
+	}
+
+	for _, p := range r.partitions {
+		var fields []string
+		if p.Size > 0 {
+			fields = append(fields, fmt.Sprintf("size=%dMiB", p.Size))
+		}
+		fields = append(fields, fmt.Sprintf("type=%s", types[p.Type]))
+		if r.img.Config.Raw.Table == config.PartitionTableGPT && p.Label != "" {
+			fields = append(fields, fmt.Sprintf("name=\"%s\"", p.Label))
+		}
+		buf.WriteString(strings.Join(fields, ", "))
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+// CreateStorage will create the sparse disk image, write the partition table
+// to it and format the partitions, so that MountStorage never destroys data.
+func (r *RawBuilder) CreateStorage() error {
+	if err := disk.CreateSparseFile(r.diskImg, r.img.Config.Raw.Size); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("sfdisk", "--quiet", r.diskImg)
+	cmd.Stdin = strings.NewReader(r.partitionScript())
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+
+	if err := r.attachLoop(); err != nil {
+		return err
+	}
+	for _, p := range r.partitions {
+		if p.Filesystem == "" {
+			continue
+		}
+		if err := formatPartition(p.device, p.Filesystem, p.Label); err != nil {
+			r.detachLoop()
+			return err
+		}
+	}
+	return r.detachLoop()
+}
+
+// attachLoop will loop-attach the disk image with partition scanning, and
+// then wait for all the partition device nodes to appear.
+func (r *RawBuilder) attachLoop() error {
+	out, err := exec.Command("losetup", "--find", "--show", "--partscan", r.diskImg).Output()
+	if err != nil {
+		return err
+	}
+	r.loopDevice = strings.TrimSpace(string(out))
+
+	for n, p := range r.partitions {
+		p.device = fmt.Sprintf("%sp%d", r.loopDevice, n+1)
+		found := false
+		for i := 0; i < 50; i++ {
+			if _, err := os.Stat(p.device); err == nil {
+				found = true
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		if !found {
+			return fmt.Errorf("Partition device did not appear: %v", p.device)
+		}
+	}
+	return nil
+}
+
+// detachLoop will release the loop device if we have one
+func (r *RawBuilder) detachLoop() error {
+	if r.loopDevice == "" {
+		return nil
+	}
+	if err := commands.ExecStdoutArgs("losetup", []string{"--detach", r.loopDevice}); err != nil {
+		return err
+	}
+	r.loopDevice = ""
+	return nil
+}
+
+// formatCommand returns the host binary used to create the filesystem
+func formatCommand(filesystem string) string {
+	if filesystem == "swap" {
+		return "mkswap"
+	}
+	return "mkfs." + filesystem
+}
+
+// formatPartition will create the partition's filesystem, applying the label
+// in the manner expected by each mkfs implementation.
+func formatPartition(device, filesystem, label string) error {
+	cmd := formatCommand(filesystem)
+	var args []string
+
+	switch filesystem {
+	case "swap":
+		if label != "" {
+			args = append(args, "-L", label)
+		}
+	case "vfat":
+		args = append(args, "-F", "32")
+		if label != "" {
+			args = append(args, "-n", label)
+		}
+	default:
+		if strings.HasPrefix(filesystem, "ext") {
+			args = append(args, "-F")
+		}
+		if label != "" {
+			args = append(args, "-L", label)
+		}
+	}
+	args = append(args, device)
+	return commands.ExecStdoutArgs(cmd, args)
+}
+
+// MountStorage will attach the disk and mount the partitions within the
+// rootfs so that the package manager can take over.
+func (r *RawBuilder) MountStorage() error {
+	if err := r.attachLoop(); err != nil {
+		return err
+	}
+
+	for _, p := range r.partitions {
+		if p.Filesystem != "" && p.MountPoint != "" {
+			r.mounted = append(r.mounted, p)
+		}
+	}
+
+	// Mount parents before their children, i.e. / before /boot
+	sort.Stable(byMountDepth(r.mounted))
+
+	for _, p := range r.mounted {
+		target := r.JoinRootPath(p.MountPoint)
+		if err := os.MkdirAll(target, 00755); err != nil {
+			return err
+		}
+		if err := disk.GetMountManager().Mount(p.device, target, p.Filesystem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bootPartition returns the partition the bootloader being installed reads
+// from. UEFI firmware can only read the ESP, so that is used for UEFI
+// installs, and otherwise a dedicated /boot is preferred over the ESP.
+func (r *RawBuilder) bootPartition() *rawPartition {
+	var esp, bootDir *rawPartition
+	for _, p := range r.mounted {
+		if p.Type == config.PartitionTypeESP && esp == nil {
+			esp = p
+		}
+		if p.MountPoint == "/boot" {
+			bootDir = p
+		}
+	}
+	if esp != nil && r.installMode&boot.CapInstallUEFI == boot.CapInstallUEFI {
+		return esp
+	}
+	if bootDir != nil {
+		return bootDir
+	}
+	return esp
+}
+
+// hasPartitionType determines whether the layout contains the given type
+func (r *RawBuilder) hasPartitionType(t config.PartitionType) bool {
+	for _, p := range r.partitions {
+		if p.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// targetPath will return the path of the given host file relative to the
+// boot partition, copying it onto the boot partition if it lives elsewhere.
+func (r *RawBuilder) targetPath(source string) (string, error) {
+	bootRoot := r.JoinDeployPath()
+	if rel, err := filepath.Rel(bootRoot, source); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel, nil
+	}
+	target := filepath.Base(source)
+	if err := disk.CopyFile(source, filepath.Join(bootRoot, target)); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// CollectAssets will build the initrd for each installed kernel and install
+// the configured bootloaders while the disk is still mounted. Without any
+// bootloaders the kernel is provided externally, so none is required.
+func (r *RawBuilder) CollectAssets() error {
+	if len(r.loaders) == 0 {
+		log.Info("No bootloaders configured, skipping kernel and initrd")
+		return nil
+	}
+	bootConf := &r.img.Config.Boot
+	kernels, err := boot.SelectKernels(r.rootfsDir, bootConf.Kernel, bootConf.Kernels)
+	if err != nil {
+		return err
+	}
+	r.kernels = kernels
+	r.initrds = nil
+
+	for _, kernel := range kernels {
+		drac := boot.NewDracut(kernel)
+		if err := drac.Exec(r.rootfsDir); err != nil {
+			return err
+		}
+		r.initrds = append(r.initrds, r.JoinRootPath(drac.OutputFilename))
+	}
+
+	return r.installBootloaders()
+}
+
+// deployKernels will ensure each kernel and initrd is on the boot partition
+// of the bootloader being installed, and set their paths relative to it.
+func (r *RawBuilder) deployKernels() error {
+	var err error
+	for n, kernel := range r.kernels {
+		if kernel.TargetPath, err = r.targetPath(kernel.Path); err != nil {
+			return err
+		}
+		if kernel.TargetInitrd, err = r.targetPath(r.initrds[n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// installBootloaders installs every configured loader in raw mode, along with
+// any firmware modes the loader and partition layout support.
+func (r *RawBuilder) installBootloaders() error {
+	defer func() {
+		r.installMode = 0
+	}()
+	for _, loader := range r.loaders {
+		caps := loader.GetCapabilities()
+		mode := boot.CapInstallRaw | caps&boot.CapInstallLegacy
+		if r.hasPartitionType(config.PartitionTypeESP) {
+			mode |= caps & boot.CapInstallUEFI
+		}
+		r.installMode = mode
+		if err := r.deployKernels(); err != nil {
+			return err
+		}
+		if err := loader.Install(mode, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnmountStorage will unmount all partitions in reverse order, check their
+// filesystems and then release the loop device.
+func (r *RawBuilder) UnmountStorage() error {
+	for i := len(r.mounted) - 1; i >= 0; i-- {
+		if err := disk.GetMountManager().Unmount(r.JoinRootPath(r.mounted[i].MountPoint)); err != nil {
+			return err
+		}
+	}
+	for _, p := range r.mounted {
+		if !strings.HasPrefix(p.Filesystem, "ext") {
+			continue
+		}
+		if err := disk.CheckFS(p.device, p.Filesystem); err != nil {
+			return err
+		}
+	}
+	return r.detachLoop()
+}
+
+// FinalizeImage will move the completed disk image into place
+func (r *RawBuilder) FinalizeImage() error {
+	return emitImage(r.diskImg, r.img.Config.Raw.FileName)
+}
+
+// GetRootDir returns the path to the mounted root partition
+func (r *RawBuilder) GetRootDir() string {
+	return r.rootfsDir
+}
+
+// Cleanup will unmount everything and release the loop device
+func (r *RawBuilder) Cleanup() {
+	log.Info("Cleaning up")
+	disk.GetMountManager().UnmountAll()
+	if err := r.detachLoop(); err != nil {
+		log.Error(err)
+	}
+}
+
+//
+// The following are all ConfigurationSource methods
+//
+
+// GetBootDevice returns the partition device containing the boot assets
+func (r *RawBuilder) GetBootDevice() string {
+	if p := r.bootPartition(); p != nil {
+		return p.device
+	}
+	return r.GetRootDevice()
+}
+
+// GetRootDevice returns the partition device mounted at /
+func (r *RawBuilder) GetRootDevice() string {
+	for _, p := range r.mounted {
+		if p.MountPoint == "/" {
+			return p.device
+		}
+	}
+	return ""
+}
+
+// JoinDeployPath will return a path within the boot partition, as this is
+// the filesystem the firmware and bootloader will read from.
+func (r *RawBuilder) JoinDeployPath(paths ...string) string {
+	bootRoot := r.rootfsDir
+	if p := r.bootPartition(); p != nil {
+		bootRoot = r.JoinRootPath(p.MountPoint)
+	}
+	return filepath.Join(bootRoot, filepath.Join(paths...))
+}
+
+// JoinRootPath will return a path within the mounted root partition
+func (r *RawBuilder) JoinRootPath(paths ...string) string {
+	return filepath.Join(r.rootfsDir, filepath.Join(paths...))
+}
+
+// GetKernel returns our default kernel object
+func (r *RawBuilder) GetKernel() *boot.Kernel {
+	return r.kernels[0]
+}
+
+// GetKernels returns all of our kernel objects
+func (r *RawBuilder) GetKernels() []*boot.Kernel {
+	return r.kernels
+}
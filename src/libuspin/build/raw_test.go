@@ -0,0 +1,49 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"libuspin/boot"
+	"libuspin/config"
+	"testing"
+)
+
+func TestRawBootPartition(t *testing.T) {
+	root := &rawPartition{SectionPartition: config.SectionPartition{Type: config.PartitionTypeLinux, MountPoint: "/"}}
+	bootDir := &rawPartition{SectionPartition: config.SectionPartition{Type: config.PartitionTypeLinux, MountPoint: "/boot"}}
+	esp := &rawPartition{SectionPartition: config.SectionPartition{Type: config.PartitionTypeESP, MountPoint: "/boot/efi"}}
+	r := &RawBuilder{mounted: []*rawPartition{root, bootDir, esp}}
+
+	r.installMode = boot.CapInstallRaw | boot.CapInstallLegacy
+	if p := r.bootPartition(); p != bootDir {
+		t.Fatalf("Legacy installs should use /boot, not %v", p.MountPoint)
+	}
+	r.installMode = boot.CapInstallRaw | boot.CapInstallUEFI
+	if p := r.bootPartition(); p != esp {
+		t.Fatalf("UEFI installs should use the ESP, not %v", p.MountPoint)
+	}
+
+	r.mounted = []*rawPartition{root, esp}
+	r.installMode = boot.CapInstallRaw | boot.CapInstallLegacy
+	if p := r.bootPartition(); p != esp {
+		t.Fatalf("The ESP should be used without a /boot, not %v", p)
+	}
+	r.mounted = []*rawPartition{root}
+	if p := r.bootPartition(); p != nil {
+		t.Fatalf("No boot partition expected, got %v", p.MountPoint)
+	}
+}
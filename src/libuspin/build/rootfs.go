@@ -0,0 +1,142 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/solus-project/libosdev/commands"
+	"github.com/solus-project/libosdev/disk"
+	"libuspin"
+	"libuspin/config"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// A RootfsBuilder installs directly into a workspace directory with no
+// backing storage, and emits either the plain tree or a tarball of it.
+type RootfsBuilder struct {
+	img       *libuspin.ImageSpec
+	rootfsDir string
+	workspace string
+}
+
+// NewRootfsBuilder should only be used by builder.go
+func NewRootfsBuilder() *RootfsBuilder {
+	return &RootfsBuilder{}
+}
+
+// Init will initialise a RootfsBuilder from the given spec
+func (r *RootfsBuilder) Init(img *libuspin.ImageSpec) error {
+	r.img = img
+	return nil
+}
+
+// JoinPath is a helper to join paths onto our root workspace directory
+func (r *RootfsBuilder) JoinPath(paths ...string) string {
+	return filepath.Join(r.workspace, filepath.Join(paths...))
+}
+
+// PrepareWorkspace sets up the rootfs directory for the RootfsBuilder
+func (r *RootfsBuilder) PrepareWorkspace(workspace string) error {
+	r.workspace = workspace
+	r.rootfsDir = r.JoinPath("rootfs")
+	return createDirs(r.workspace, r.rootfsDir)
+}
+
+// CreateStorage will empty the rootfs directory, so that packages are never
+// installed over a partially installed rootfs when resuming a build.
+func (r *RootfsBuilder) CreateStorage() error {
+	if err := checkUnmounted(r.rootfsDir); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(r.rootfsDir); err != nil {
+		return err
+	}
+	return createDirs(r.rootfsDir)
+}
+
+// MountStorage does nothing as we install straight into the workspace
+func (r *RootfsBuilder) MountStorage() error {
+	return nil
+}
+
+// CollectAssets does nothing as a rootfs is never booted directly
+func (r *RootfsBuilder) CollectAssets() error {
+	return nil
+}
+
+// UnmountStorage does nothing as we install straight into the workspace
+func (r *RootfsBuilder) UnmountStorage() error {
+	return nil
+}
+
+// sourceDateEpoch returns the time from SOURCE_DATE_EPOCH, if set, so that
+// tarballs may be reproduced bit for bit.
+func sourceDateEpoch() (time.Time, error) {
+	epoch := os.Getenv("SOURCE_DATE_EPOCH")
+	if epoch == "" {
+		return time.Time{}, nil
+	}
+	secs, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid SOURCE_DATE_EPOCH: %v", epoch)
+	}
+	return time.Unix(secs, 0), nil
+}
+
+// FinalizeImage will emit the rootfs in the configured form
+func (r *RootfsBuilder) FinalizeImage() error {
+	outputFilename, err := filepath.Abs(r.img.Config.Rootfs.FileName)
+	if err != nil {
+		return err
+	}
+
+	if r.img.Config.Rootfs.Output == config.RootfsOutputDirectory {
+		// Never merge into, or nest within, whatever is already there
+		if _, err := os.Lstat(outputFilename); err == nil {
+			return fmt.Errorf("Refusing to replace existing rootfs output: %v", outputFilename)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Rename(r.rootfsDir, outputFilename); err == nil {
+			return nil
+		}
+		// Most likely across devices, so fall back to a copy that retains
+		// ownership, device nodes and xattrs
+		return commands.ExecStdoutArgs("cp", []string{"-a", r.rootfsDir, outputFilename})
+	}
+
+	clamp, err := sourceDateEpoch()
+	if err != nil {
+		return err
+	}
+	return CreateTarball(r.rootfsDir, outputFilename, r.img.Config.Rootfs.Compression, clamp)
+}
+
+// GetRootDir returns the path to the rootfs directory
+func (r *RootfsBuilder) GetRootDir() string {
+	return r.rootfsDir
+}
+
+// Cleanup will ensure nothing is left mounted by the package manager
+func (r *RootfsBuilder) Cleanup() {
+	log.Info("Cleaning up")
+	disk.GetMountManager().UnmountAll()
+}
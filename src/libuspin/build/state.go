@@ -0,0 +1,172 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/BurntSushi/toml"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// A Stage is a checkpoint in the Builder lifecycle
+type Stage string
+
+const (
+	// StageWorkspace is complete once PrepareWorkspace has run
+	StageWorkspace Stage = "workspace"
+
+	// StageStorage is complete once CreateStorage has run
+	StageStorage Stage = "storage"
+
+	// StagePackages is complete once all packages are installed
+	StagePackages Stage = "packages"
+
+	// StageAssets is complete once CollectAssets has run
+	StageAssets Stage = "assets"
+
+	// StageFinalized is complete once FinalizeImage has run
+	StageFinalized Stage = "finalized"
+)
+
+// stageOrder is the order in which the stages are completed
+var stageOrder = []Stage{
+	StageWorkspace,
+	StageStorage,
+	StagePackages,
+	StageAssets,
+	StageFinalized,
+}
+
+// stateFile is the name of the build state within the workspace
+const stateFile = ".uspin-state"
+
+// A State records which stages of a build are complete, along with the hashes
+// of the inputs they were completed with, so that a failed build can be
+// resumed from the first incomplete stage.
+type State struct {
+	Spin         string  `toml:"spin"`          // Absolute path to the .spin file being built
+	SpinHash     string  `toml:"spin_hash"`     // Hash of the .spin file and any it extends
+	PackagesHash string  `toml:"packages_hash"` // Hash of the packages file and any it includes
+	Stages       []Stage `toml:"stages"`        // Completed stages, in order
+
+	path string
+}
+
+// LoadState will load the build state from the workspace, returning an empty
+// state if there is none yet.
+func LoadState(workspace string) (*State, error) {
+	s := &State{path: filepath.Join(workspace, stateFile)}
+	if _, err := os.Stat(s.path); os.IsNotExist(err) {
+		return s, nil
+	}
+	if _, err := toml.DecodeFile(s.path, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Save will atomically write the state back to the workspace
+func (s *State) Save() error {
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := toml.NewEncoder(f).Encode(s); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Done determines whether the stage has been completed
+func (s *State) Done(stage Stage) bool {
+	for _, st := range s.Stages {
+		if st == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// Complete will record the stage as completed and save the state
+func (s *State) Complete(stage Stage) error {
+	if !s.Done(stage) {
+		s.Stages = append(s.Stages, stage)
+	}
+	return s.Save()
+}
+
+// Invalidate will forget the given stage and every stage after it
+func (s *State) Invalidate(from Stage) {
+	invalid := false
+	var stages []Stage
+	for _, st := range stageOrder {
+		if st == from {
+			invalid = true
+		}
+		if !invalid && s.Done(st) {
+			stages = append(stages, st)
+		}
+	}
+	s.Stages = stages
+}
+
+// Update will record the hashes of the inputs to this build, forgetting the
+// stages that were completed with different inputs, and reports which of
+// them have changed. Packages are never installed over a partially installed
+// rootfs, so storage is always recreated if the packages are not complete.
+func (s *State) Update(spinHash, packagesHash string) (spinChanged, packagesChanged bool) {
+	if len(s.Stages) > 0 {
+		spinChanged = s.SpinHash != spinHash
+		packagesChanged = s.PackagesHash != packagesHash
+	}
+	if spinChanged {
+		s.Invalidate(StageStorage)
+	}
+	if packagesChanged {
+		s.Invalidate(StagePackages)
+	}
+	if !s.Done(StagePackages) {
+		s.Invalidate(StageStorage)
+	}
+	s.SpinHash = spinHash
+	s.PackagesHash = packagesHash
+	return spinChanged, packagesChanged
+}
+
+// HashFiles will return a hash of the paths and contents of the files, so
+// that changes to any of them may be detected.
+func HashFiles(files []string) (string, error) {
+	h := sha256.New()
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(h, file+"\x00")
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,209 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"io/ioutil"
+	"libuspin"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-state")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := LoadState(dir)
+	if err != nil {
+		t.Fatalf("Cannot load empty state: %v", err)
+	}
+	if len(s.Stages) != 0 {
+		t.Fatalf("New state should have no stages: %v", s.Stages)
+	}
+	s.SpinHash = "spin"
+	for _, stage := range []Stage{StageWorkspace, StageStorage, StagePackages, StageAssets} {
+		if err := s.Complete(stage); err != nil {
+			t.Fatalf("Cannot complete stage %v: %v", stage, err)
+		}
+	}
+
+	if s, err = LoadState(dir); err != nil {
+		t.Fatalf("Cannot reload state: %v", err)
+	}
+	if s.SpinHash != "spin" || !s.Done(StageAssets) || s.Done(StageFinalized) {
+		t.Fatalf("State not saved: %v %v", s.SpinHash, s.Stages)
+	}
+
+	s.Invalidate(StagePackages)
+	if !s.Done(StageStorage) || s.Done(StagePackages) || s.Done(StageAssets) {
+		t.Fatalf("Wrong stages invalidated: %v", s.Stages)
+	}
+}
+
+func TestHashFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-hash")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "main.packages")
+	if err := ioutil.WriteFile(file, []byte("nano\n"), 00644); err != nil {
+		t.Fatalf("Cannot write file: %v", err)
+	}
+	a, err := HashFiles([]string{file})
+	if err != nil {
+		t.Fatalf("Cannot hash files: %v", err)
+	}
+	if err := ioutil.WriteFile(file, []byte("vim\n"), 00644); err != nil {
+		t.Fatalf("Cannot write file: %v", err)
+	}
+	b, err := HashFiles([]string{file})
+	if err != nil {
+		t.Fatalf("Cannot hash files: %v", err)
+	}
+	if a == b {
+		t.Fatalf("Hash did not change with the contents")
+	}
+}
+
+func TestResumePartialPackages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-resume")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A build that failed part way through installing packages
+	r := NewRootfsBuilder()
+	if err := r.PrepareWorkspace(dir); err != nil {
+		t.Fatalf("Cannot prepare workspace: %v", err)
+	}
+	s, err := LoadState(dir)
+	if err != nil {
+		t.Fatalf("Cannot load state: %v", err)
+	}
+	s.Update("spin", "packages")
+	for _, stage := range []Stage{StageWorkspace, StageStorage} {
+		if err := s.Complete(stage); err != nil {
+			t.Fatalf("Cannot complete stage %v: %v", stage, err)
+		}
+	}
+	partial := filepath.Join(r.GetRootDir(), "usr", "bin")
+	if err := createDirs(partial); err != nil {
+		t.Fatalf("Cannot create partial rootfs: %v", err)
+	}
+
+	// Resume it exactly as uspin does
+	if s, err = LoadState(dir); err != nil {
+		t.Fatalf("Cannot reload state: %v", err)
+	}
+	if spin, packages := s.Update("spin", "packages"); spin || packages {
+		t.Fatalf("Inputs should not have changed")
+	}
+	if s.Done(StageStorage) {
+		t.Fatalf("Storage must be recreated when packages are incomplete")
+	}
+	r = NewRootfsBuilder()
+	if err := r.PrepareWorkspace(dir); err != nil {
+		t.Fatalf("Cannot prepare workspace: %v", err)
+	}
+	if err := r.CreateStorage(); err != nil {
+		t.Fatalf("Cannot create storage: %v", err)
+	}
+	entries, err := ioutil.ReadDir(r.GetRootDir())
+	if err != nil {
+		t.Fatalf("Rootfs directory missing: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("Partial rootfs was not removed: %v", entries[0].Name())
+	}
+
+	// Changed packages must also start from fresh storage
+	for _, stage := range []Stage{StageStorage, StagePackages, StageAssets} {
+		if err := s.Complete(stage); err != nil {
+			t.Fatalf("Cannot complete stage %v: %v", stage, err)
+		}
+	}
+	if _, packages := s.Update("spin", "changed"); !packages || s.Done(StageStorage) {
+		t.Fatalf("Changed packages did not invalidate storage: %v", s.Stages)
+	}
+}
+
+func TestResumeIncludeChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-resume")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"image.spin":     "[image]\npackages = \"main.packages\"\ntype = \"rootfs\"\n\n[rootfs]\nfilename = \"rootfs.tar.gz\"\n",
+		"main.packages":  "%include extra.packages\nnano\n",
+		"extra.packages": "vim\n",
+	}
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 00644); err != nil {
+			t.Fatalf("Cannot write %v: %v", name, err)
+		}
+	}
+
+	loadHash := func() string {
+		is, err := libuspin.NewImageSpec(filepath.Join(dir, "image.spin"))
+		if err != nil {
+			t.Fatalf("Cannot load image spec: %v", err)
+		}
+		if len(is.PackageFiles) != 2 {
+			t.Fatalf("Packages file and include should be tracked: %v", is.PackageFiles)
+		}
+		hash, err := HashFiles(is.PackageFiles)
+		if err != nil {
+			t.Fatalf("Cannot hash packages: %v", err)
+		}
+		return hash
+	}
+
+	s, err := LoadState(dir)
+	if err != nil {
+		t.Fatalf("Cannot load state: %v", err)
+	}
+	s.Update("spin", loadHash())
+	for _, stage := range []Stage{StageWorkspace, StageStorage, StagePackages} {
+		if err := s.Complete(stage); err != nil {
+			t.Fatalf("Cannot complete stage %v: %v", stage, err)
+		}
+	}
+
+	// Editing only the included file must reinstall the packages
+	if err := ioutil.WriteFile(filepath.Join(dir, "extra.packages"), []byte("emacs\n"), 00644); err != nil {
+		t.Fatalf("Cannot edit include: %v", err)
+	}
+	if s, err = LoadState(dir); err != nil {
+		t.Fatalf("Cannot reload state: %v", err)
+	}
+	oldHash := s.PackagesHash
+	if _, changed := s.Update("spin", loadHash()); !changed || s.PackagesHash == oldHash {
+		t.Fatalf("Editing an include did not change the packages hash")
+	}
+	if s.Done(StagePackages) {
+		t.Fatalf("Packages stage should be invalidated: %v", s.Stages)
+	}
+}
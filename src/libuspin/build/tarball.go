@@ -0,0 +1,196 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"libuspin/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// xattrPrefix is the PAX record prefix used by GNU tar & friends for xattrs
+	xattrPrefix = "SCHILY.xattr."
+)
+
+// An inode uniquely identifies a file for hardlink detection
+type inode struct {
+	dev uint64
+	ino uint64
+}
+
+// getXattrs returns all extended attributes of the given path, which must not
+// be a symlink.
+func getXattrs(path string) (map[string]string, error) {
+	sz, err := syscall.Listxattr(path, nil)
+	if err != nil || sz == 0 {
+		// Filesystem may simply not support xattrs
+		if err == syscall.ENOTSUP {
+			return nil, nil
+		}
+		return nil, err
+	}
+	buf := make([]byte, sz)
+	if sz, err = syscall.Listxattr(path, buf); err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]string)
+	for _, name := range strings.Split(string(buf[:sz]), "\x00") {
+		if name == "" {
+			continue
+		}
+		vsz, err := syscall.Getxattr(path, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, vsz)
+		if vsz, err = syscall.Getxattr(path, name, value); err != nil {
+			return nil, err
+		}
+		ret[name] = string(value[:vsz])
+	}
+	return ret, nil
+}
+
+// WriteTarball will write the tree at root into w as an uncompressed tarball.
+//
+// The output is reproducible: entries are written in lexical order, user and
+// group names are omitted in favour of the numeric IDs, access and change
+// times are dropped, and if clamp is non-zero any modification time later
+// than clamp is clamped to it (i.e. SOURCE_DATE_EPOCH).
+// Ownership, permissions, xattrs, hardlinks and device nodes are preserved.
+func WriteTarball(w io.Writer, root string, clamp time.Time) error {
+	tw := tar.NewWriter(w)
+	links := make(map[inode]string)
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		// Sockets are runtime only and cannot be represented in a tarball
+		if info.Mode()&os.ModeSocket != 0 {
+			return nil
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		target := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if target, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(info, target)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Format = tar.FormatPAX
+		hdr.Uname = ""
+		hdr.Gname = ""
+		hdr.AccessTime = time.Time{}
+		hdr.ChangeTime = time.Time{}
+		if !clamp.IsZero() && hdr.ModTime.After(clamp) {
+			hdr.ModTime = clamp
+		}
+		// PAX format would otherwise retain sub-second precision
+		hdr.ModTime = hdr.ModTime.Truncate(time.Second)
+
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && info.Mode().IsRegular() && st.Nlink > 1 {
+			id := inode{dev: uint64(st.Dev), ino: st.Ino}
+			if first, ok := links[id]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = first
+				hdr.Size = 0
+			} else {
+				links[id] = name
+			}
+		}
+
+		if info.Mode()&os.ModeSymlink == 0 {
+			xattrs, err := getXattrs(path)
+			if err != nil {
+				return fmt.Errorf("Cannot read xattrs of %v: %v", path, err)
+			}
+			for key, value := range xattrs {
+				if hdr.PAXRecords == nil {
+					hdr.PAXRecords = make(map[string]string)
+				}
+				hdr.PAXRecords[xattrPrefix+key] = value
+			}
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+
+		fi, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer fi.Close()
+		_, err = io.Copy(tw, fi)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// CreateTarball will create a tarball of root at the output path, optionally
+// gzip compressed.
+func CreateTarball(root, output string, compression config.ArchiveCompression, clamp time.Time) error {
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	switch compression {
+	case config.ArchiveCompressionNone:
+		return WriteTarball(out, root, clamp)
+	case config.ArchiveCompressionGzip:
+		gz := gzip.NewWriter(out)
+		if err := WriteTarball(gz, root, clamp); err != nil {
+			return err
+		}
+		return gz.Close()
+	default:
+		return fmt.Errorf("Unknown compression type: %v", compression)
+	}
+}
@@ -0,0 +1,151 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"libuspin/config"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// makeTestTree creates a small rootfs style tree for tarball tests
+func makeTestTree(t *testing.T) string {
+	root, err := ioutil.TempDir("", "uspin-tarball")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "usr", "bin"), 00755); err != nil {
+		t.Fatalf("Cannot create directories: %v", err)
+	}
+	nano := filepath.Join(root, "usr", "bin", "nano")
+	if err := ioutil.WriteFile(nano, []byte("nano"), 00755); err != nil {
+		t.Fatalf("Cannot write file: %v", err)
+	}
+	if err := os.Link(nano, filepath.Join(root, "usr", "bin", "rnano")); err != nil {
+		t.Fatalf("Cannot create hardlink: %v", err)
+	}
+	if err := os.Symlink("usr/bin", filepath.Join(root, "bin")); err != nil {
+		t.Fatalf("Cannot create symlink: %v", err)
+	}
+	return root
+}
+
+func TestWriteTarball(t *testing.T) {
+	root := makeTestTree(t)
+	defer os.RemoveAll(root)
+
+	clamp := time.Unix(1000, 0)
+	var buf bytes.Buffer
+	if err := WriteTarball(&buf, root, clamp); err != nil {
+		t.Fatalf("Failed to write tarball: %v", err)
+	}
+
+	expected := []struct {
+		name     string
+		typeflag byte
+		linkname string
+	}{
+		{"bin", tar.TypeSymlink, "usr/bin"},
+		{"usr/", tar.TypeDir, ""},
+		{"usr/bin/", tar.TypeDir, ""},
+		{"usr/bin/nano", tar.TypeReg, ""},
+		{"usr/bin/rnano", tar.TypeLink, "usr/bin/nano"},
+	}
+
+	tr := tar.NewReader(&buf)
+	for _, want := range expected {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("Missing entry %v: %v", want.name, err)
+		}
+		if hdr.Name != want.name || hdr.Typeflag != want.typeflag || hdr.Linkname != want.linkname {
+			t.Fatalf("Unexpected entry: %v (%c -> %v)", hdr.Name, hdr.Typeflag, hdr.Linkname)
+		}
+		if !hdr.ModTime.Equal(clamp) {
+			t.Fatalf("Modification time not clamped for %v: %v", hdr.Name, hdr.ModTime)
+		}
+		if hdr.Uname != "" || hdr.Gname != "" {
+			t.Fatalf("User and group names should be omitted for %v", hdr.Name)
+		}
+		if hdr.Uid != os.Getuid() {
+			t.Fatalf("Ownership not preserved for %v: %v", hdr.Name, hdr.Uid)
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Fatalf("Unexpected trailing entries in tarball")
+	}
+}
+
+func TestWriteTarballReproducible(t *testing.T) {
+	root := makeTestTree(t)
+	defer os.RemoveAll(root)
+
+	var a, b bytes.Buffer
+	clamp := time.Unix(1000, 0)
+	if err := WriteTarball(&a, root, clamp); err != nil {
+		t.Fatalf("Failed to write tarball: %v", err)
+	}
+	// Touch a file, which must not affect the clamped output
+	now := time.Now()
+	if err := os.Chtimes(filepath.Join(root, "usr", "bin", "nano"), now, now); err != nil {
+		t.Fatalf("Cannot touch file: %v", err)
+	}
+	if err := WriteTarball(&b, root, clamp); err != nil {
+		t.Fatalf("Failed to write tarball: %v", err)
+	}
+	if !bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Fatalf("Tarball output is not reproducible")
+	}
+}
+
+func TestCreateTarball(t *testing.T) {
+	root := makeTestTree(t)
+	defer os.RemoveAll(root)
+	dir, err := ioutil.TempDir("", "uspin-tarball")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "rootfs.tar.gz")
+	if err := CreateTarball(root, output, config.ArchiveCompressionGzip, time.Unix(1000, 0)); err != nil {
+		t.Fatalf("Failed to create tarball: %v", err)
+	}
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatalf("Cannot open tarball: %v", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Tarball is not gzip compressed: %v", err)
+	}
+	if hdr, err := tar.NewReader(gz).Next(); err != nil || hdr.Name != "bin" {
+		t.Fatalf("Invalid compressed tarball: %v", err)
+	}
+
+	if err := CreateTarball(root, filepath.Join(dir, "rootfs.tar.xz"), "xz", time.Time{}); err == nil {
+		t.Fatalf("Unsupported compression should fail")
+	}
+}
@@ -0,0 +1,236 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"fmt"
+	"github.com/solus-project/libosdev/disk"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// checkBinaries will ensure all of the given host binaries are available
+// before a builder goes doing anything.
+func checkBinaries(binaries []string) error {
+	for _, bin := range binaries {
+		if _, err := exec.LookPath(bin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const (
+	// workspaceMarker is created in every workspace, and a directory without
+	// one is never removed
+	workspaceMarker = ".uspin-workspace"
+
+	// workspaceLock is the advisory lock file for a workspace, containing the
+	// PID of the build using it
+	workspaceLock = ".uspin-lock"
+)
+
+// A Workspace is a unique directory for a single build, which is locked for as
+// long as the build is using it so that concurrent builds cannot collide.
+type Workspace struct {
+	Path string // Absolute path to the workspace
+
+	lock *os.File
+}
+
+// NewWorkspace will create and lock a new workspace for the named build
+// within the root directory, which is shared by all builds.
+func NewWorkspace(root, name string) (*Workspace, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 00755); err != nil {
+		return nil, err
+	}
+	path, err := ioutil.TempDir(root, name+"-")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 00755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(path, workspaceMarker), nil, 00644); err != nil {
+		return nil, err
+	}
+	w := &Workspace{Path: path}
+	if err := w.Lock(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// FindWorkspace will return the most recently used workspace of the named build
+// within root that still has a build state for the given .spin file, locking
+// it for reuse. A nil Workspace is returned if there is none to resume.
+func FindWorkspace(root, name, spin string) (*Workspace, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(root, name+"-*"))
+	if err != nil {
+		return nil, err
+	}
+	var found *Workspace
+	var foundTime time.Time
+	for _, path := range paths {
+		// Other builds may share our name as a prefix, i.e. budgie-lite
+		if !isWorkspaceSuffix(strings.TrimPrefix(filepath.Base(path), name+"-")) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(path, workspaceMarker)); err != nil {
+			continue
+		}
+		st, err := os.Stat(filepath.Join(path, stateFile))
+		if err != nil || (found != nil && !st.ModTime().After(foundTime)) {
+			continue
+		}
+		if state, err := LoadState(path); err != nil || state.Spin != spin {
+			continue
+		}
+		found = &Workspace{Path: path}
+		foundTime = st.ModTime()
+	}
+	if found == nil {
+		return nil, nil
+	}
+	if err := found.Lock(); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// isWorkspaceSuffix determines whether s is the random suffix that
+// NewWorkspace appends to the name of the build
+func isWorkspaceSuffix(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Lock will take the advisory lock on the workspace and record our PID in it,
+// failing if another build already holds it.
+func (w *Workspace) Lock() error {
+	f, err := os.OpenFile(filepath.Join(w.Path, workspaceLock), os.O_RDWR|os.O_CREATE, 00644)
+	if err != nil {
+		return err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		pid, _ := ioutil.ReadAll(f)
+		f.Close()
+		return fmt.Errorf("Workspace %v is in use by PID %v", w.Path, strings.TrimSpace(string(pid)))
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.WriteString(strconv.Itoa(os.Getpid()) + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	w.lock = f
+	return nil
+}
+
+// Unlock will release the lock on the workspace, if we hold it
+func (w *Workspace) Unlock() {
+	if w.lock == nil {
+		return
+	}
+	syscall.Flock(int(w.lock.Fd()), syscall.LOCK_UN)
+	w.lock.Close()
+	w.lock = nil
+}
+
+// Remove will delete the workspace and release the lock
+func (w *Workspace) Remove() error {
+	defer w.Unlock()
+	return removeWorkspace(w.Path)
+}
+
+// removeWorkspace will delete the workspace at path, refusing to do so if it
+// lacks the workspace marker, or if anything is still mounted within it.
+func removeWorkspace(path string) error {
+	if _, err := os.Stat(filepath.Join(path, workspaceMarker)); err != nil {
+		return fmt.Errorf("Refusing to remove %v as it is not a uspin workspace", path)
+	}
+	if err := checkUnmounted(path); err != nil {
+		return err
+	}
+	return os.RemoveAll(path)
+}
+
+// checkUnmounted will return an error if anything is mounted at or within
+// path, as removing a bind mount of the host would be disastrous.
+func checkUnmounted(path string) error {
+	mounts, err := ioutil.ReadFile("/proc/self/mounts")
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(mounts), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if fields[1] == path || strings.HasPrefix(fields[1], path+"/") {
+			return fmt.Errorf("Refusing to remove %v as %v is still mounted", path, fields[1])
+		}
+	}
+	return nil
+}
+
+// createDirs will create all of the given directories
+func createDirs(dirs ...string) error {
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 00755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emitImage will move a completed image from the workspace to the configured
+// output filename, relative to the current directory.
+func emitImage(source, filename string) error {
+	outputFilename, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(source, outputFilename); err == nil {
+		return nil
+	}
+	// Most likely across devices, so fall back to a copy
+	return disk.CopyFile(source, outputFilename)
+}
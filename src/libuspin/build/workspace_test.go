@@ -0,0 +1,137 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWorkspace(t *testing.T) {
+	root, err := ioutil.TempDir("", "uspin-workspace")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	a, err := NewWorkspace(root, "solus")
+	if err != nil {
+		t.Fatalf("Cannot create workspace: %v", err)
+	}
+	b, err := NewWorkspace(root, "solus")
+	if err != nil {
+		t.Fatalf("Cannot create second workspace: %v", err)
+	}
+	defer b.Unlock()
+	if a.Path == b.Path || !strings.HasPrefix(filepath.Base(a.Path), "solus-") {
+		t.Fatalf("Workspaces are not unique: %v %v", a.Path, b.Path)
+	}
+
+	pid, err := ioutil.ReadFile(filepath.Join(a.Path, workspaceLock))
+	if err != nil || strings.TrimSpace(string(pid)) != strconv.Itoa(os.Getpid()) {
+		t.Fatalf("Lock does not contain our PID: %v", string(pid))
+	}
+
+	// A second lock on the same workspace must fail while held
+	other := &Workspace{Path: a.Path}
+	if err := other.Lock(); err == nil {
+		t.Fatalf("Workspace should not be locked twice")
+	}
+
+	if err := a.Remove(); err != nil {
+		t.Fatalf("Cannot remove workspace: %v", err)
+	}
+	if _, err := os.Stat(a.Path); !os.IsNotExist(err) {
+		t.Fatalf("Workspace was not removed: %v", a.Path)
+	}
+}
+
+func TestRemoveWorkspaceMarker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uspin-notworkspace")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := removeWorkspace(dir); err == nil {
+		t.Fatalf("Directory without a marker should not be removed")
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatalf("Directory without a marker was removed: %v", err)
+	}
+}
+
+func TestFindWorkspace(t *testing.T) {
+	root, err := ioutil.TempDir("", "uspin-workspace")
+	if err != nil {
+		t.Fatalf("Cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	if w, err := FindWorkspace(root, "solus", "/solus.spin"); err != nil || w != nil {
+		t.Fatalf("No workspace should be found: %v %v", w, err)
+	}
+
+	w, err := NewWorkspace(root, "solus")
+	if err != nil {
+		t.Fatalf("Cannot create workspace: %v", err)
+	}
+	s, err := LoadState(w.Path)
+	if err != nil {
+		t.Fatalf("Cannot load state: %v", err)
+	}
+	s.Spin = "/solus.spin"
+	if err := s.Complete(StageWorkspace); err != nil {
+		t.Fatalf("Cannot save state: %v", err)
+	}
+
+	// Newer workspaces of other builds sharing the name must be ignored
+	for _, other := range []struct{ name, spin string }{
+		{"solus-lite", "/solus-lite.spin"},
+		{"solus", "/elsewhere/solus.spin"},
+	} {
+		ow, err := NewWorkspace(root, other.name)
+		if err != nil {
+			t.Fatalf("Cannot create workspace: %v", err)
+		}
+		ostate, err := LoadState(ow.Path)
+		if err != nil {
+			t.Fatalf("Cannot load state: %v", err)
+		}
+		ostate.Spin = other.spin
+		if err := ostate.Complete(StageWorkspace); err != nil {
+			t.Fatalf("Cannot save state: %v", err)
+		}
+		ow.Unlock()
+	}
+
+	// Still locked by the "failed" build
+	if _, err := FindWorkspace(root, "solus", "/solus.spin"); err == nil {
+		t.Fatalf("Locked workspace should not be resumed")
+	}
+	w.Unlock()
+
+	found, err := FindWorkspace(root, "solus", "/solus.spin")
+	if err != nil || found == nil || found.Path != w.Path {
+		t.Fatalf("Workspace not found for resume: %v %v", found, err)
+	}
+	found.Unlock()
+}
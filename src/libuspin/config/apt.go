@@ -0,0 +1,60 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// AptGroupMode determines how @group lines are installed with apt
+type AptGroupMode string
+
+const (
+	// AptGroupModeTask installs groups as tasksel tasks, i.e. "ubuntu-desktop^"
+	AptGroupModeTask AptGroupMode = "task"
+
+	// AptGroupModeMetapackage installs groups as plain metapackages
+	AptGroupModeMetapackage AptGroupMode = "metapackage"
+)
+
+// SectionApt is the debootstrap/apt specific configuration
+type SectionApt struct {
+	Suite      string       `toml:"suite"`      // Suite to debootstrap, i.e. "stretch" or "xenial"
+	Variant    string       `toml:"variant"`    // Optional debootstrap variant, i.e. "minbase"
+	Components []string     `toml:"components"` // Archive components, defaults to main
+	Groups     AptGroupMode `toml:"groups"`     // How to install @group lines
+}
+
+// ValidateSectionApt will determine if the configuration is valid for apt
+func ValidateSectionApt(a *SectionApt) error {
+	a.Suite = strings.TrimSpace(a.Suite)
+	if a.Suite == "" {
+		return errors.New("apt.suite must be set for apt")
+	}
+	a.Variant = strings.TrimSpace(a.Variant)
+	if len(a.Components) == 0 {
+		return errors.New("apt.components cannot be empty")
+	}
+	switch a.Groups {
+	case AptGroupModeTask, AptGroupModeMetapackage:
+	default:
+		return fmt.Errorf("Unknown apt group mode: %v", a.Groups)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultCmdline is the kernel command line used when none is configured.
+	// The root= argument is always added by the bootloader.
+	DefaultCmdline = "ro rd.luks=0 rd.md=0 quiet splash"
+
+	// BootEntryDefault is the reserved name of the primary boot entry
+	BootEntryDefault = "live"
+)
+
+// SectionBootEntry describes an additional [[boot.entry]] that every
+// bootloader will render alongside the default entry
+type SectionBootEntry struct {
+	Name    string `toml:"name"`    // Unique identifier for the entry, i.e. "safe-graphics"
+	Title   string `toml:"title"`   // Text shown in the boot menu
+	Cmdline string `toml:"cmdline"` // Replaces the base command line, if set
+	Options string `toml:"options"` // Appended to the command line, i.e. "nomodeset"
+}
+
+// SectionBoot describes the [boot] portion of a spin file
+type SectionBoot struct {
+	Cmdline string             `toml:"cmdline"` // Base kernel command line
+	Kernel  string             `toml:"kernel"`  // Default kernel, by flavour, version or file name
+	Kernels []string           `toml:"kernels"` // Secondary kernels to make bootable
+	Entries []SectionBootEntry `toml:"entry"`   // Additional boot entries
+}
+
+// ValidateSectionBoot will determine if the boot configuration is valid
+func ValidateSectionBoot(b *SectionBoot) error {
+	b.Cmdline = strings.TrimSpace(b.Cmdline)
+
+	names := map[string]bool{
+		BootEntryDefault: true,
+	}
+	for n := range b.Entries {
+		entry := &b.Entries[n]
+		entry.Name = strings.TrimSpace(entry.Name)
+		entry.Title = strings.TrimSpace(entry.Title)
+		if entry.Name == "" || strings.ContainsAny(entry.Name, " \t/") {
+			return fmt.Errorf("Invalid name for boot entry: '%v'", entry.Name)
+		}
+		if names[entry.Name] {
+			return fmt.Errorf("Duplicate or reserved boot entry: %v", entry.Name)
+		}
+		names[entry.Name] = true
+		if entry.Title == "" {
+			return fmt.Errorf("Missing title for boot entry: %v", entry.Name)
+		}
+		if strings.Contains(entry.Cmdline+entry.Options, "root=") {
+			return fmt.Errorf("Boot entry %v cannot override root=", entry.Name)
+		}
+	}
+	b.Kernel = strings.TrimSpace(b.Kernel)
+	for n, kernel := range b.Kernels {
+		if b.Kernels[n] = strings.TrimSpace(kernel); b.Kernels[n] == "" {
+			return fmt.Errorf("Empty kernel name in boot.kernels")
+		}
+	}
+	if strings.Contains(b.Cmdline, "root=") {
+		return fmt.Errorf("boot.cmdline cannot override root=")
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"errors"
+	"strings"
+)
+
+// SectionDnf is the dnf/yum specific configuration
+type SectionDnf struct {
+	// ReleaseVer is passed as --releasever, as it cannot be detected from an
+	// empty installroot. This is also what $releasever expands to in repo URIs.
+	ReleaseVer string `toml:"releasever"`
+}
+
+// ValidateSectionDnf will determine if the configuration is valid for dnf
+func ValidateSectionDnf(d *SectionDnf) error {
+	d.ReleaseVer = strings.TrimSpace(d.ReleaseVer)
+	if d.ReleaseVer == "" {
+		return errors.New("dnf.releasever must be set for dnf and yum")
+	}
+	return nil
+}
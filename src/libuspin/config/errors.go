@@ -0,0 +1,75 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A KeyError is a problem with a single key of a spin file
+type KeyError struct {
+	Key     string // Dotted path to the key, i.e. liveos.label
+	Message string
+}
+
+func (k *KeyError) Error() string {
+	return k.Key + ": " + k.Message
+}
+
+// keyErrorf will return a new KeyError for the key
+func keyErrorf(key, format string, args ...interface{}) *KeyError {
+	return &KeyError{Key: key, Message: fmt.Sprintf(format, args...)}
+}
+
+// KeyErrors is returned when a spin file has one or more problems, so that
+// they can all be fixed at once.
+type KeyErrors []*KeyError
+
+// Error will return each error on its own line
+func (k KeyErrors) Error() string {
+	var lines []string
+	for _, e := range k {
+		lines = append(lines, e.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// add will record err against the key, if set. The keys of any KeyError
+// within err are taken to be relative to the key.
+func (k *KeyErrors) add(key string, err error) {
+	switch e := err.(type) {
+	case nil:
+	case KeyErrors:
+		for _, ke := range e {
+			k.add(key, ke)
+		}
+	case *KeyError:
+		*k = append(*k, keyErrorf(key+"."+e.Key, "%v", e.Message))
+	default:
+		*k = append(*k, keyErrorf(key, "%v", err))
+	}
+}
+
+// errorOrNil will return nil if there are no errors, to avoid returning a
+// non-nil error interface holding an empty list
+func (k KeyErrors) errorOrNil() error {
+	if len(k) == 0 {
+		return nil
+	}
+	return k
+}
@@ -0,0 +1,184 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// spinHeader is decoded before the rest of a spin file to find its base
+type spinHeader struct {
+	Extends string `toml:"extends"` // Path to the spin file this one extends
+}
+
+// decodeSpin will decode the spin file at cpath over iconf, after first
+// decoding the file it extends, if any, so that every file only overrides
+// the keys it sets. The merge rules are:
+//
+//   - Keys and tables override those of the base, key by key
+//   - Arrays, such as liveos.bootloaders, replace the base array entirely
+//   - [[repo]] entries replace any base repo with the same name, or are appended
+//   - [vars] are merged key by key
+//
+// Relative paths set in a base file are resolved against its own directory,
+// and any keys that were not understood are added to unknown.
+func decodeSpin(iconf *ImageConfiguration, cpath string, chain []string, unknown *KeyErrors) error {
+	abspath, err := filepath.Abs(cpath)
+	if err != nil {
+		return err
+	}
+	for _, p := range chain {
+		if p == abspath {
+			return fmt.Errorf("Cycle in extends: %v", strings.Join(append(chain, abspath), " -> "))
+		}
+	}
+	chain = append(chain, abspath)
+
+	data, err := ioutil.ReadFile(cpath)
+	if err != nil {
+		return err
+	}
+
+	header := spinHeader{}
+	md, err := toml.Decode(string(data), &header)
+	if err != nil {
+		return err
+	}
+
+	if header.Extends = strings.TrimSpace(header.Extends); header.Extends != "" {
+		base := header.Extends
+		if !filepath.IsAbs(base) {
+			base = filepath.Join(filepath.Dir(cpath), base)
+		}
+		if err := decodeSpin(iconf, base, chain, unknown); err != nil {
+			return fmt.Errorf("Failed to load %v: %v", base, err)
+		}
+		iconf.resolvePaths(filepath.Dir(base))
+	}
+
+	// Arrays must be replaced, not decoded over the base elements
+	repos := iconf.Repos
+	iconf.Repos = nil
+	clearDefinedSlices(reflect.ValueOf(iconf).Elem(), md, nil)
+
+	if md, err = toml.Decode(string(data), iconf); err != nil {
+		return err
+	}
+	reported := make(map[string]bool)
+	for _, key := range md.Undecoded() {
+		// extends is handled by the header
+		if key.String() == "extends" {
+			continue
+		}
+		// Only report the unknown table, not every key within it
+		parent := false
+		for n := 1; n < len(key); n++ {
+			if reported[key[:n].String()] {
+				parent = true
+				break
+			}
+		}
+		reported[key.String()] = true
+		if parent {
+			continue
+		}
+		// Name the file when the key comes from a base spin file
+		if len(chain) > 1 {
+			*unknown = append(*unknown, keyErrorf(key.String(), "Unknown key in %v", cpath))
+		} else {
+			*unknown = append(*unknown, keyErrorf(key.String(), "Unknown key"))
+		}
+	}
+	iconf.Repos = mergeRepos(repos, iconf.Repos)
+	iconf.Files = append(iconf.Files, abspath)
+	return nil
+}
+
+// clearDefinedSlices will reset every slice within the struct whose key is
+// set in the file, as the decoder would otherwise reuse the existing elements.
+func clearDefinedSlices(rv reflect.Value, md toml.MetaData, key []string) {
+	rt := rv.Type()
+	for n := 0; n < rt.NumField(); n++ {
+		tag := strings.Split(rt.Field(n).Tag.Get("toml"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		fieldKey := append(key[:len(key):len(key)], tag)
+		if !md.IsDefined(fieldKey...) {
+			continue
+		}
+		field := rv.Field(n)
+		switch field.Kind() {
+		case reflect.Slice:
+			field.Set(reflect.Zero(field.Type()))
+		case reflect.Struct:
+			clearDefinedSlices(field, md, fieldKey)
+		}
+	}
+}
+
+// mergeRepos will replace any base repo with a repo of the same name,
+// appending the remaining repos in order.
+func mergeRepos(base, repos []SectionRepo) []SectionRepo {
+	ret := append([]SectionRepo(nil), base...)
+	for _, repo := range repos {
+		replaced := false
+		for n := range ret {
+			if ret[n].Name == repo.Name {
+				ret[n] = repo
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			ret = append(ret, repo)
+		}
+	}
+	return ret
+}
+
+// resolvePaths will make any relative paths absolute against baseDir, so that
+// paths inherited from a base spin file remain relative to that file.
+func (i *ImageConfiguration) resolvePaths(baseDir string) {
+	resolve := func(path *string) {
+		if *path = strings.TrimSpace(*path); *path != "" && !filepath.IsAbs(*path) {
+			if abs, err := filepath.Abs(filepath.Join(baseDir, *path)); err == nil {
+				*path = abs
+			}
+		}
+	}
+	resolve(&i.Image.Packages)
+	resolve(&i.Isolinux.Template)
+	resolve(&i.Isolinux.Background)
+	for n := range i.Repos {
+		if !strings.Contains(i.Repos[n].Key, "://") {
+			resolve(&i.Repos[n].Key)
+		}
+	}
+}
+
+// Dump will write the fully resolved configuration as TOML, i.e. to inspect
+// the result of extending a base spin file.
+func (i *ImageConfiguration) Dump(w io.Writer) error {
+	return toml.NewEncoder(w).Encode(i)
+}
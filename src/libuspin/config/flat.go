@@ -0,0 +1,45 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"strings"
+)
+
+// SectionFlat is the flat (unpartitioned) filesystem image configuration
+type SectionFlat struct {
+	FileName     string `toml:"filename"`      // The resulting filename for this image spin
+	RootfsSize   int    `toml:"rootfs_size"`   // Size of the image in megabytes (default 4000)
+	RootfsFormat string `toml:"rootfs_format"` // Format of the rootfs, defaults to ext4
+	Shrink       bool   `toml:"shrink"`        // Shrink the filesystem to minimum size when done
+}
+
+// ValidateSectionFlat will determine if the configuration is valid for a flat
+// image, returning every problem found as KeyErrors.
+func ValidateSectionFlat(f *SectionFlat) error {
+	var errs KeyErrors
+	f.FileName = strings.TrimSpace(f.FileName)
+	if f.FileName == "" {
+		errs = append(errs, keyErrorf("filename", "Invalid filename for flat image"))
+	}
+	errs = append(errs, validateRootfsImage(f.RootfsSize, &f.RootfsFormat)...)
+	// We rely on resize2fs for shrinking
+	if f.Shrink && !strings.HasPrefix(f.RootfsFormat, "ext") {
+		errs = append(errs, keyErrorf("shrink", "Shrinking is only supported for ext2/3/4 flat images"))
+	}
+	return errs.errorOrNil()
+}
@@ -17,12 +17,20 @@
 package config
 
 import (
-	"errors"
-	"fmt"
 	"github.com/solus-project/libosdev/disk"
+	"regexp"
 	"strings"
 )
 
+// maxLabelLength is the longest volume ID permitted by ISO9660
+const maxLabelLength = 32
+
+var (
+	// labelRegex restricts the volume ID to characters that survive both
+	// ISO9660 and the CDLABEL= kernel argument
+	labelRegex = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+)
+
 // SectionLiveOS is the Live ISO specific configuration
 type SectionLiveOS struct {
 	Compression  disk.CompressionType `toml:"compression"`   // The type of compression to use on the LiveOS
@@ -37,24 +45,31 @@ type SectionLiveOS struct {
 	Bootloaders []LoaderType `toml:"bootloaders"` // Which bootloaders to enable
 }
 
-// ValidateSectionLiveOS will determine if the configuration is valid for a LiveOS
+// ValidateSectionLiveOS will determine if the configuration is valid for a
+// LiveOS, returning every problem found as KeyErrors.
 func ValidateSectionLiveOS(l *SectionLiveOS) error {
+	var errs KeyErrors
 	switch l.Compression {
 	case disk.CompressionGzip, disk.CompressionXZ:
 	default:
-		return fmt.Errorf("Unknown compression type: %v", l.Compression)
+		errs = append(errs, keyErrorf("compression", "Unknown compression type: %v", l.Compression))
 	}
 	l.FileName = strings.TrimSpace(l.FileName)
 	if l.FileName == "" {
-		return errors.New("Invalid filename for livecd")
+		errs = append(errs, keyErrorf("filename", "Invalid filename for livecd"))
 	}
 	l.BootDir = strings.TrimSpace(l.BootDir)
 	if strings.HasPrefix(l.BootDir, "/") {
-		return errors.New("Invalid path for bootdir")
+		errs = append(errs, keyErrorf("bootdir", "Invalid path for bootdir"))
 	}
 	l.Label = strings.TrimSpace(l.Label)
-	if strings.Contains(l.Label, " ") || strings.Contains(l.Label, "/") {
-		return errors.New("Invalid label for LiveOS")
+	if !labelRegex.MatchString(l.Label) {
+		errs = append(errs, keyErrorf("label", "Invalid label '%v', only letters, digits, '.', '-' and '_' are permitted", l.Label))
+	}
+	if len(l.Label) > maxLabelLength {
+		errs = append(errs, keyErrorf("label", "Label is longer than %v characters: %v", maxLabelLength, l.Label))
 	}
-	return nil
+	errs = append(errs, validateRootfsImage(l.RootfsSize, &l.RootfsFormat)...)
+	errs = append(errs, validateLoaders(l.Bootloaders)...)
+	return errs.errorOrNil()
 }
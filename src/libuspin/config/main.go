@@ -21,9 +21,9 @@ package config
 import (
 	"errors"
 	"fmt"
-	"github.com/BurntSushi/toml"
-	"io/ioutil"
-	"os"
+	log "github.com/Sirupsen/logrus"
+	"path/filepath"
+	"runtime"
 	"strings"
 )
 
@@ -34,20 +34,66 @@ type ImageType string
 // supported implementations
 type LoaderType string
 
+// A PackageManagerType is the package manager used to populate the rootfs
+type PackageManagerType string
+
 const (
 	// ImageTypeLiveOS is an ISO type image that may also be USB compatible
 	ImageTypeLiveOS ImageType = "liveos"
+
+	// ImageTypeRaw is a partitioned disk image, i.e. for VMs or dd'ing to a disk
+	ImageTypeRaw ImageType = "raw"
+
+	// ImageTypeFlat is an unpartitioned filesystem image, i.e. an ext4 loopback
+	// image used as the base for evobuild chroots.
+	ImageTypeFlat ImageType = "flat"
+
+	// ImageTypeRootfs is a plain rootfs tree or tarball for container and
+	// chroot tooling.
+	ImageTypeRootfs ImageType = "rootfs"
+
+	// ImageTypeOCI is an OCI image layout for container runtimes & registries
+	ImageTypeOCI ImageType = "oci"
 )
 
 const (
 	// LoaderTypeSyslinux refers to syslinux + isolinux
 	LoaderTypeSyslinux LoaderType = "syslinux"
+
+	// LoaderTypeSystemdBoot refers to systemd-boot, formerly gummiboot
+	LoaderTypeSystemdBoot LoaderType = "systemd-boot"
+
+	// LoaderTypeGrub2 refers to GRUB 2, for both legacy and UEFI booting
+	LoaderTypeGrub2 LoaderType = "grub2"
+)
+
+const (
+	// PackageManagerEopkg is the Solus package manager
+	PackageManagerEopkg PackageManagerType = "eopkg"
+
+	// PackageManagerDnf is the Fedora package manager
+	PackageManagerDnf PackageManagerType = "dnf"
+
+	// PackageManagerYum is the older Fedora/RHEL package manager, which
+	// shares the dnf backend
+	PackageManagerYum PackageManagerType = "yum"
+
+	// PackageManagerApt is the Debian-family package manager, bootstrapped
+	// with debootstrap
+	PackageManagerApt PackageManagerType = "apt"
+
+	// PackageManagerSwupd is the Clear Linux software updater, which only
+	// installs bundles
+	PackageManagerSwupd PackageManagerType = "swupd"
 )
 
 // SectionImage describes the [image] portion of a spin file
 type SectionImage struct {
-	Packages string    `toml:"packages"` // Path to the packages file
-	Type     ImageType `toml:"type"`     // Type of image to construct
+	Packages       string             `toml:"packages"`        // Path to the packages file
+	Type           ImageType          `toml:"type"`            // Type of image to construct
+	PackageManager PackageManagerType `toml:"package_manager"` // Package manager for the rootfs
+	Strict         bool               `toml:"strict"`          // Turn deprecation warnings into errors
+	Workspace      string             `toml:"workspace"`       // Root for build workspaces, relative to the current directory
 }
 
 // SectionBranding describes the image branding rules
@@ -59,16 +105,54 @@ type SectionBranding struct {
 // ImageConfiguration is the configuration for an image build
 type ImageConfiguration struct {
 	Image    SectionImage    `toml:"image"`
+	Repos    []SectionRepo   `toml:"repo"`
 	Branding SectionBranding `toml:"branding"`
+	Boot     SectionBoot     `toml:"boot"`
 	LiveOS   SectionLiveOS   `toml:"liveos"`
 	Isolinux SectionIsolinux `toml:"isolinux"`
+	Raw      SectionRaw      `toml:"raw"`
+	Flat     SectionFlat     `toml:"flat"`
+	Rootfs   SectionRootfs   `toml:"rootfs"`
+	OCI      SectionOCI      `toml:"oci"`
+	Dnf      SectionDnf      `toml:"dnf"`
+	Apt      SectionApt      `toml:"apt"`
+	Swupd    SectionSwupd    `toml:"swupd"`
+
+	// Vars are used to evaluate conditionals in the packages file
+	Vars map[string]string `toml:"vars"`
+
+	// Files are the absolute paths of the spin file and any it extends, in
+	// the order they were decoded
+	Files []string `toml:"-"`
+}
+
+// Options control how a configuration is loaded
+type Options struct {
+	// Vars override any set in the [vars] section, i.e. from the command line
+	Vars map[string]string
+
+	// Lenient turns unknown keys into warnings rather than errors
+	Lenient bool
+
+	// Workspace overrides the workspace root set in the [image] section
+	Workspace string
 }
 
 // New will return a new ImageConfiguration for the given path and attempt to
-// parse it. This function will return a nil ImageConfiguration if parsing
-// fails.
+// parse it, along with any spin file it extends. This function will return a
+// nil ImageConfiguration if parsing fails.
 func New(cpath string) (*ImageConfiguration, error) {
+	return NewWithOptions(cpath, &Options{})
+}
+
+// NewWithOptions will return a new ImageConfiguration for the given path,
+// exactly like New, applying the options once parsed.
+func NewWithOptions(cpath string, opts *Options) (*ImageConfiguration, error) {
 	iconf := &ImageConfiguration{
+		Image: SectionImage{
+			PackageManager: PackageManagerEopkg,
+			Workspace:      "workspace",
+		},
 		LiveOS: SectionLiveOS{
 			RootfsFormat: "ext4",
 			RootfsSize:   4000,
@@ -79,43 +163,127 @@ func New(cpath string) (*ImageConfiguration, error) {
 			},
 			Label: "uspin.ISO",
 		},
+		Boot: SectionBoot{
+			Cmdline: DefaultCmdline,
+		},
+		Isolinux: SectionIsolinux{
+			Timeout: 50,
+		},
+		Raw: SectionRaw{
+			Size:  4000,
+			Table: PartitionTableGPT,
+		},
+		Flat: SectionFlat{
+			RootfsFormat: "ext4",
+			RootfsSize:   4000,
+		},
+		Rootfs: SectionRootfs{
+			Output:      RootfsOutputTarball,
+			Compression: ArchiveCompressionGzip,
+		},
+		OCI: SectionOCI{
+			Tag:          "latest",
+			Architecture: runtime.GOARCH,
+		},
+		Apt: SectionApt{
+			Components: []string{"main"},
+			Groups:     AptGroupModeTask,
+		},
+		Swupd: SectionSwupd{
+			Version:    SwupdVersionLatest,
+			ContentURL: DefaultSwupdContentURL,
+		},
+	}
+	// Attempt to populate config from the toml spin file and its bases
+	var unknown KeyErrors
+	if err := decodeSpin(iconf, cpath, nil, &unknown); err != nil {
+		return nil, err
+	}
+
+	var errs KeyErrors
+
+	// Unknown keys are most likely typos, which would silently use defaults
+	for _, e := range unknown {
+		if opts.Lenient {
+			log.WithFields(log.Fields{
+				"key": e.Key,
+			}).Warning(e.Message)
+			continue
+		}
+		errs = append(errs, e)
 	}
-	var data []byte
-	var err error
-	var fi *os.File
 
-	fi, err = os.Open(cpath)
+	if iconf.Vars == nil {
+		iconf.Vars = make(map[string]string)
+	}
+	for k, v := range opts.Vars {
+		iconf.Vars[k] = v
+	}
+	builtins, err := builtinVars(filepath.Dir(cpath))
 	if err != nil {
 		return nil, err
 	}
-	defer fi.Close()
+	for k, v := range builtins {
+		if _, ok := iconf.Vars[k]; !ok {
+			iconf.Vars[k] = v
+		}
+	}
 
-	// Read the configuration file in
-	if data, err = ioutil.ReadAll(fi); err != nil {
+	// Expand before validating, so that the final values are checked
+	if err := iconf.expandVars(); err != nil {
 		return nil, err
 	}
 
-	// Attempt to populate config from the toml spin file
-	if _, err = toml.Decode(string(data), iconf); err != nil {
-		return nil, err
+	if opts.Workspace != "" {
+		iconf.Image.Workspace = opts.Workspace
+	}
+	if iconf.Image.Workspace = strings.TrimSpace(iconf.Image.Workspace); iconf.Image.Workspace == "" {
+		errs.add("image.workspace", errors.New("Cannot be empty"))
 	}
 
 	// Ensure errors is non empty!
 	iconf.Image.Packages = strings.TrimSpace(iconf.Image.Packages)
 	if iconf.Image.Packages == "" {
-		return nil, errors.New("image.packages cannot be empty")
+		errs.add("image.packages", errors.New("Cannot be empty"))
+	}
+
+	switch iconf.Image.PackageManager {
+	case PackageManagerEopkg:
+	case PackageManagerDnf, PackageManagerYum:
+		errs.add("dnf", ValidateSectionDnf(&iconf.Dnf))
+	case PackageManagerApt:
+		errs.add("apt", ValidateSectionApt(&iconf.Apt))
+	case PackageManagerSwupd:
+		errs.add("swupd", ValidateSectionSwupd(&iconf.Swupd))
+	default:
+		errs.add("image.package_manager", fmt.Errorf("Unknown package manager: %v", iconf.Image.PackageManager))
 	}
 
 	// Validate the type
 	// TODO: Add more image types!
 	switch iconf.Image.Type {
 	case ImageTypeLiveOS:
-		if err := ValidateSectionLiveOS(&iconf.LiveOS); err != nil {
-			return nil, err
-		}
+		errs.add("liveos", ValidateSectionLiveOS(&iconf.LiveOS))
+	case ImageTypeRaw:
+		errs.add("raw", ValidateSectionRaw(&iconf.Raw))
+	case ImageTypeFlat:
+		errs.add("flat", ValidateSectionFlat(&iconf.Flat))
+	case ImageTypeRootfs:
+		errs.add("rootfs", ValidateSectionRootfs(&iconf.Rootfs))
+	case ImageTypeOCI:
+		errs.add("oci", ValidateSectionOCI(&iconf.OCI, iconf.Image.Workspace))
 	default:
-		return nil, fmt.Errorf("Unknown image type: %v", iconf.Image.Type)
+		errs.add("image.type", fmt.Errorf("Unknown image type: %v", iconf.Image.Type))
 	}
 
+	errs.add("repo", ValidateSectionRepos(iconf.Repos, filepath.Dir(cpath)))
+	errs.add("boot", ValidateSectionBoot(&iconf.Boot))
+
+	// Bootloader assets live alongside the .spin file
+	errs.add("isolinux", ValidateSectionIsolinux(&iconf.Isolinux, filepath.Dir(cpath)))
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
 	return iconf, nil
 }
@@ -17,11 +17,24 @@
 package config
 
 import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
 const (
 	confTestPath = "../../../testdata/minimal.spin"
+	rawTestPath  = "../../../testdata/raw.spin"
+	flatTestPath = "../../../testdata/flat.spin"
+	themedPath   = "../../../testdata/themed.spin"
+	editionPath  = "../../../testdata/editions/budgie.spin"
+	loopPath     = "../../../testdata/editions/loop-a.spin"
+	varsPath     = "../../../testdata/vars.spin"
+	typosPath    = "../../../testdata/typos.spin"
+	invalidPath  = "../../../testdata/invalid.spin"
 )
 
 func TestConfig(t *testing.T) {
@@ -38,4 +51,304 @@ func TestConfig(t *testing.T) {
 	if c.LiveOS.Compression != "gzip" {
 		t.Fatalf("Invalid compression: %v", c.LiveOS.Compression)
 	}
+	if c.Image.PackageManager != PackageManagerEopkg {
+		t.Fatalf("Invalid default package manager: %v", c.Image.PackageManager)
+	}
+}
+
+func TestConfigRaw(t *testing.T) {
+	c, err := New(rawTestPath)
+	if err != nil {
+		t.Fatalf("Couldn't open good raw config: %v", err)
+	}
+	if c.Image.Type != ImageTypeRaw {
+		t.Fatalf("Invalid type")
+	}
+	if len(c.Raw.Partitions) != 3 {
+		t.Fatalf("Invalid number of partitions: %v", len(c.Raw.Partitions))
+	}
+	if c.Raw.Partitions[1].Filesystem != "swap" {
+		t.Fatalf("Swap partition not normalised: %v", c.Raw.Partitions[1].Filesystem)
+	}
+	if c.Raw.Partitions[2].Type != PartitionTypeLinux {
+		t.Fatalf("Partition type did not default to linux: %v", c.Raw.Partitions[2].Type)
+	}
+}
+
+func TestConfigFlat(t *testing.T) {
+	c, err := New(flatTestPath)
+	if err != nil {
+		t.Fatalf("Couldn't open good flat config: %v", err)
+	}
+	if c.Flat.RootfsFormat != "ext4" {
+		t.Fatalf("Invalid default rootfs format: %v", c.Flat.RootfsFormat)
+	}
+	if c.Flat.RootfsSize != 6000 || !c.Flat.Shrink {
+		t.Fatalf("Invalid flat configuration")
+	}
+}
+
+func TestConfigIsolinux(t *testing.T) {
+	c, err := New(themedPath)
+	if err != nil {
+		t.Fatalf("Couldn't open good themed config: %v", err)
+	}
+	if c.Isolinux.TemplateData == "" {
+		t.Fatalf("isolinux template was not loaded")
+	}
+	if c.Isolinux.Timeout != 100 {
+		t.Fatalf("Invalid isolinux timeout: %v", c.Isolinux.Timeout)
+	}
+	if len(c.Isolinux.Entries) != 1 || c.Isolinux.Entries[0].Kernel != "hdt.c32" {
+		t.Fatalf("Invalid isolinux entries")
+	}
+}
+
+func TestConfigIsolinuxBadTemplate(t *testing.T) {
+	i := &SectionIsolinux{
+		Template: "broken.isolinux",
+	}
+	if err := ValidateSectionIsolinux(i, "../../../testdata"); err == nil {
+		t.Fatalf("Broken template should not validate")
+	}
+}
+
+func TestConfigIsolinuxReservedEntries(t *testing.T) {
+	for _, name := range []string{BootEntryDefault, IsolinuxEntryLocal, "kernel-4.9"} {
+		i := &SectionIsolinux{
+			Entries: []SectionIsolinuxEntry{{Name: name, Title: "Clash", Kernel: "hdt.c32"}},
+		}
+		if err := ValidateSectionIsolinux(i, "../../../testdata"); err == nil {
+			t.Fatalf("Entry named %v should be rejected", name)
+		}
+	}
+}
+
+func TestConfigBoot(t *testing.T) {
+	c, err := New(confTestPath)
+	if err != nil {
+		t.Fatalf("Couldn't open good config: %v", err)
+	}
+	if c.Boot.Cmdline != DefaultCmdline {
+		t.Fatalf("Invalid default cmdline: %v", c.Boot.Cmdline)
+	}
+
+	b := &SectionBoot{
+		Entries: []SectionBootEntry{
+			{Name: "safe-graphics", Title: "Safe graphics", Options: "nomodeset"},
+		},
+	}
+	if err := ValidateSectionBoot(b); err != nil {
+		t.Fatalf("Valid boot entries should validate: %v", err)
+	}
+	b.Entries = append(b.Entries, SectionBootEntry{Name: BootEntryDefault, Title: "Oops"})
+	if err := ValidateSectionBoot(b); err == nil {
+		t.Fatalf("Reserved boot entry name should not validate")
+	}
+}
+
+func TestConfigPackageManagers(t *testing.T) {
+	if err := ValidateSectionDnf(&SectionDnf{}); err == nil {
+		t.Fatalf("dnf should require releasever")
+	}
+	a := &SectionApt{Suite: " stretch ", Components: []string{"main"}, Groups: AptGroupModeTask}
+	if err := ValidateSectionApt(a); err != nil {
+		t.Fatalf("Valid apt section should validate: %v", err)
+	}
+	if a.Suite != "stretch" {
+		t.Fatalf("Suite not normalised: %v", a.Suite)
+	}
+	a.Groups = "tasks"
+	if err := ValidateSectionApt(a); err == nil {
+		t.Fatalf("Invalid apt group mode should not validate")
+	}
+}
+
+func TestConfigSwupd(t *testing.T) {
+	s := &SectionSwupd{Version: "12380", ContentURL: DefaultSwupdContentURL}
+	if err := ValidateSectionSwupd(s); err != nil {
+		t.Fatalf("Valid swupd section should validate: %v", err)
+	}
+	s.Version = "12380.1"
+	if err := ValidateSectionSwupd(s); err == nil {
+		t.Fatalf("Invalid swupd version should not validate")
+	}
+}
+
+func TestConfigOptions(t *testing.T) {
+	c, err := NewWithOptions(confTestPath, &Options{
+		Vars: map[string]string{"edition": "lite"},
+	})
+	if err != nil {
+		t.Fatalf("Couldn't open good config: %v", err)
+	}
+	if c.Vars["edition"] != "lite" {
+		t.Fatalf("Options vars not applied: %v", c.Vars)
+	}
+	if c.Image.Workspace != "workspace" {
+		t.Fatalf("Invalid default workspace: %v", c.Image.Workspace)
+	}
+	if c, err = NewWithOptions(confTestPath, &Options{Workspace: "/var/tmp/uspin"}); err != nil {
+		t.Fatalf("Couldn't open good config: %v", err)
+	}
+	if c.Image.Workspace != "/var/tmp/uspin" {
+		t.Fatalf("Options workspace not applied: %v", c.Image.Workspace)
+	}
+}
+
+func TestConfigExtends(t *testing.T) {
+	c, err := New(editionPath)
+	if err != nil {
+		t.Fatalf("Couldn't open good edition config: %v", err)
+	}
+	if c.LiveOS.FileName != "Solus-Budgie.iso" || c.LiveOS.Label != "SolusLive" {
+		t.Fatalf("Invalid liveos merge: %v %v", c.LiveOS.FileName, c.LiveOS.Label)
+	}
+	if len(c.LiveOS.Bootloaders) != 1 || c.LiveOS.Bootloaders[0] != LoaderTypeSystemdBoot {
+		t.Fatalf("Bootloaders should be replaced: %v", c.LiveOS.Bootloaders)
+	}
+	if c.Branding.Title != "Solus Budgie" || c.Branding.StartString != "Start Solus" {
+		t.Fatalf("Invalid branding merge: %v", c.Branding)
+	}
+	if c.Vars["edition"] != "budgie" || c.Vars["arch"] != "x86_64" {
+		t.Fatalf("Invalid vars merge: %v", c.Vars)
+	}
+	if len(c.Repos) != 2 || c.Repos[0].Name != "Solus" || c.Repos[1].Name != "Budgie" {
+		t.Fatalf("Invalid repo merge: %v", c.Repos)
+	}
+	if !strings.Contains(c.Repos[0].URI, "unstable") {
+		t.Fatalf("Repo not overridden: %v", c.Repos[0].URI)
+	}
+	if !filepath.IsAbs(c.Image.Packages) || filepath.Base(c.Image.Packages) != "minimal.packages" {
+		t.Fatalf("Inherited path not resolved: %v", c.Image.Packages)
+	}
+	if c.Isolinux.TemplateData == "" {
+		t.Fatalf("Inherited isolinux template not loaded")
+	}
+	if len(c.Files) != 2 || filepath.Base(c.Files[0]) != "base.spin" {
+		t.Fatalf("Invalid spin files: %v", c.Files)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := c.Dump(buf); err != nil {
+		t.Fatalf("Failed to dump config: %v", err)
+	}
+	if !strings.Contains(buf.String(), "Solus-Budgie.iso") {
+		t.Fatalf("Dumped config is missing settings:\n%v", buf.String())
+	}
+}
+
+func TestConfigExtendsCycle(t *testing.T) {
+	_, err := New(loopPath)
+	if err == nil || !strings.Contains(err.Error(), "Cycle") {
+		t.Fatalf("Cyclic extends should not load: %v", err)
+	}
+}
+
+func TestConfigVars(t *testing.T) {
+	os.Setenv("SOURCE_DATE_EPOCH", "1480000000")
+	defer os.Unsetenv("SOURCE_DATE_EPOCH")
+
+	c, err := NewWithOptions(varsPath, &Options{
+		Vars: map[string]string{"version": "1.2.2"},
+	})
+	if err != nil {
+		t.Fatalf("Couldn't open good vars config: %v", err)
+	}
+	if c.LiveOS.FileName != "Solus-1.2.2-20161124.iso" {
+		t.Fatalf("Filename not expanded: %v", c.LiveOS.FileName)
+	}
+	if c.LiveOS.Label != "Solus1.2.2" || c.Branding.Title != "Solus 1.2.2" {
+		t.Fatalf("Strings not expanded: %v %v", c.LiveOS.Label, c.Branding.Title)
+	}
+
+	// Expanded values must still be valid
+	_, err = NewWithOptions(varsPath, &Options{
+		Vars: map[string]string{"version": "1.2.2 beta"},
+	})
+	if err == nil {
+		t.Fatalf("Invalid expanded label should not validate")
+	}
+}
+
+func TestExpandVars(t *testing.T) {
+	l := &SectionLiveOS{FileName: "${name}.iso", Label: "${missing}"}
+	if err := expandValue(reflect.ValueOf(l).Elem(), map[string]string{"name": "Solus"}); err == nil {
+		t.Fatalf("Undefined variable should not expand")
+	}
+	if l.FileName != "Solus.iso" {
+		t.Fatalf("Filename not expanded: %v", l.FileName)
+	}
+}
+
+// errorKeys returns the keys of the KeyErrors in err
+func errorKeys(t *testing.T, err error) []string {
+	errs, ok := err.(KeyErrors)
+	if !ok {
+		t.Fatalf("Expected KeyErrors, got: %v", err)
+	}
+	var keys []string
+	for _, e := range errs {
+		keys = append(keys, e.Key)
+	}
+	return keys
+}
+
+func TestConfigUnknownKeys(t *testing.T) {
+	_, err := New(typosPath)
+	if keys := strings.Join(errorKeys(t, err), " "); keys != "liveos.boot_loaders live_os" {
+		t.Fatalf("Wrong unknown keys: %v", keys)
+	}
+	if _, err := NewWithOptions(typosPath, &Options{Lenient: true}); err != nil {
+		t.Fatalf("Unknown keys should be allowed when lenient: %v", err)
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	_, err := New(invalidPath)
+	keys := strings.Join(errorKeys(t, err), " ")
+	if keys != "liveos.label liveos.rootfs_size liveos.rootfs_format liveos.bootloaders" {
+		t.Fatalf("Wrong invalid keys: %v", keys)
+	}
+	l := &SectionLiveOS{
+		Compression:  "gzip",
+		FileName:     "Solus.iso",
+		Label:        "Solus-1.2.1-20161124-Budgie-Edition",
+		RootfsSize:   4000,
+		RootfsFormat: "ext4",
+		Bootloaders:  []LoaderType{LoaderTypeSyslinux, LoaderTypeSyslinux},
+	}
+	keys = strings.Join(errorKeys(t, ValidateSectionLiveOS(l)), " ")
+	if keys != "label bootloaders" {
+		t.Fatalf("Wrong invalid keys: %v", keys)
+	}
+
+	r := &SectionRaw{
+		Size:        100,
+		Table:       PartitionTableMBR,
+		Bootloaders: []LoaderType{"lilo"},
+		Partitions: []SectionPartition{
+			{Size: 0, Type: PartitionTypeBIOS},
+			{Size: 512, Type: PartitionTypeLinux, MountPoint: "boot"},
+		},
+	}
+	keys = strings.Join(errorKeys(t, ValidateSectionRaw(r)), " ")
+	if keys != "filename bootloaders partition.type partition.size partition.filesystem partition.mountpoint partition.mountpoint size" {
+		t.Fatalf("Wrong invalid raw keys: %v", keys)
+	}
+}
+
+func TestValidateSectionOCI(t *testing.T) {
+	for _, filename := range []string{"solus-base", "out/solus-base", "/var/tmp/solus-base"} {
+		o := &SectionOCI{FileName: filename, Tag: "latest"}
+		if err := ValidateSectionOCI(o, "workspace"); err != nil {
+			t.Fatalf("Valid OCI filename %v rejected: %v", filename, err)
+		}
+	}
+	for _, filename := range []string{".", "./", "..", "/", "workspace", "workspace/.."} {
+		o := &SectionOCI{FileName: filename, Tag: "latest"}
+		if keys := strings.Join(errorKeys(t, ValidateSectionOCI(o, "workspace")), " "); keys != "filename" {
+			t.Fatalf("Dangerous OCI filename %v not rejected: %v", filename, keys)
+		}
+	}
 }
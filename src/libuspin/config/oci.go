@@ -0,0 +1,63 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// SectionOCI is the configuration for OCI container image layouts
+type SectionOCI struct {
+	FileName     string            `toml:"filename"`     // Directory to write the image layout to
+	Tag          string            `toml:"tag"`          // Reference name for the image, i.e. "latest"
+	Architecture string            `toml:"architecture"` // GOARCH style architecture, defaults to the host
+	Entrypoint   []string          `toml:"entrypoint"`   // Entrypoint of the container
+	Cmd          []string          `toml:"cmd"`          // Default arguments to the entrypoint
+	Env          []string          `toml:"env"`          // Environment in KEY=VALUE form
+	WorkingDir   string            `toml:"working_dir"`  // Initial working directory
+	Labels       map[string]string `toml:"labels"`       // Arbitrary metadata for the image
+}
+
+// ValidateSectionOCI will determine if the configuration is valid for an OCI
+// image, returning every problem found as KeyErrors. The layout directory may
+// never be the current directory or contain the workspace root, as any
+// previous layout is replaced when the image is written.
+func ValidateSectionOCI(o *SectionOCI, workspace string) error {
+	var errs KeyErrors
+	o.FileName = strings.TrimSpace(o.FileName)
+	if o.FileName == "" {
+		errs = append(errs, keyErrorf("filename", "Invalid filename for OCI image"))
+	} else if filepath.Clean(o.FileName) == "." {
+		errs = append(errs, keyErrorf("filename", "Cannot write the OCI image to the current directory"))
+	} else if containsPath(o.FileName, workspace) {
+		errs = append(errs, keyErrorf("filename", "Cannot write the OCI image over the workspace root '%v'", workspace))
+	}
+	o.Tag = strings.TrimSpace(o.Tag)
+	if o.Tag == "" {
+		errs = append(errs, keyErrorf("tag", "Invalid tag for OCI image"))
+	}
+	for _, env := range o.Env {
+		if !strings.Contains(env, "=") || strings.HasPrefix(env, "=") {
+			errs = append(errs, keyErrorf("env", "Invalid environment variable for OCI image: %v", env))
+		}
+	}
+	if o.WorkingDir != "" && !strings.HasPrefix(o.WorkingDir, "/") {
+		errs = append(errs, keyErrorf("working_dir", "Invalid working_dir for OCI image: %v", o.WorkingDir))
+	}
+	return errs.errorOrNil()
+}
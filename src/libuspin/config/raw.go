@@ -0,0 +1,166 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// A PartitionTable is the type of partition table to write to a raw disk
+type PartitionTable string
+
+// A PartitionType restricts partitions to the types we know how to create
+type PartitionType string
+
+const (
+	// PartitionTableGPT is a GUID Partition Table, required for UEFI
+	PartitionTableGPT PartitionTable = "gpt"
+
+	// PartitionTableMBR is a legacy msdos partition table
+	PartitionTableMBR PartitionTable = "mbr"
+)
+
+const (
+	// PartitionTypeLinux is a normal Linux filesystem partition
+	PartitionTypeLinux PartitionType = "linux"
+
+	// PartitionTypeESP is the EFI System Partition
+	PartitionTypeESP PartitionType = "esp"
+
+	// PartitionTypeSwap is a Linux swap partition
+	PartitionTypeSwap PartitionType = "swap"
+
+	// PartitionTypeBIOS is the BIOS boot partition used by GRUB on GPT disks
+	PartitionTypeBIOS PartitionType = "bios"
+)
+
+// SectionPartition describes a single [[raw.partition]] entry in the layout
+type SectionPartition struct {
+	Size       int           `toml:"size"`       // Size in megabytes, 0 to fill the remaining space
+	Type       PartitionType `toml:"type"`       // Type of partition, defaults to linux
+	Filesystem string        `toml:"filesystem"` // Filesystem to format the partition with
+	MountPoint string        `toml:"mountpoint"` // Where to mount within the rootfs, if anywhere
+	Label      string        `toml:"label"`      // Filesystem & partition label
+}
+
+// SectionRaw is the raw partitioned disk specific configuration
+type SectionRaw struct {
+	FileName string         `toml:"filename"` // The resulting filename for this image spin
+	Size     int            `toml:"size"`     // Size of the disk in megabytes (default 4000)
+	Table    PartitionTable `toml:"table"`    // Type of partition table, defaults to gpt
+
+	Bootloaders []LoaderType `toml:"bootloaders"` // Which bootloaders to install to the disk
+
+	Partitions []SectionPartition `toml:"partition"` // Partition layout, in disk order
+}
+
+// ValidateSectionRaw will determine if the configuration is valid for a raw
+// disk, returning every problem found as KeyErrors.
+func ValidateSectionRaw(r *SectionRaw) error {
+	var errs KeyErrors
+	r.FileName = strings.TrimSpace(r.FileName)
+	if r.FileName == "" {
+		errs = append(errs, keyErrorf("filename", "Invalid filename for raw disk"))
+	}
+
+	switch r.Table {
+	case PartitionTableGPT, PartitionTableMBR:
+	default:
+		errs = append(errs, keyErrorf("table", "Unknown partition table type: %v", r.Table))
+	}
+
+	errs = append(errs, validateLoaders(r.Bootloaders)...)
+
+	if len(r.Partitions) == 0 {
+		errs = append(errs, keyErrorf("partition", "No partitions defined for raw disk"))
+		return errs
+	}
+	if r.Table == PartitionTableMBR && len(r.Partitions) > 4 {
+		errs = append(errs, keyErrorf("partition", "Only 4 primary partitions are supported with mbr"))
+	}
+
+	// Leave room for the partition table itself and alignment
+	totalSize := 2
+	haveRoot := false
+	mounts := make(map[string]bool)
+
+	for n := range r.Partitions {
+		p := &r.Partitions[n]
+
+		if p.Type == "" {
+			p.Type = PartitionTypeLinux
+		}
+		p.Filesystem = strings.TrimSpace(p.Filesystem)
+		p.MountPoint = strings.TrimSpace(p.MountPoint)
+		p.Label = strings.TrimSpace(p.Label)
+
+		switch p.Type {
+		case PartitionTypeLinux, PartitionTypeESP:
+			if p.Filesystem == "" {
+				errs = append(errs, keyErrorf("partition.filesystem", "Missing filesystem for partition %d", n+1))
+			}
+		case PartitionTypeSwap:
+			if p.MountPoint != "" {
+				errs = append(errs, keyErrorf("partition.mountpoint", "Swap partition %d cannot be mounted", n+1))
+			}
+			p.Filesystem = "swap"
+		case PartitionTypeBIOS:
+			if r.Table != PartitionTableGPT {
+				errs = append(errs, keyErrorf("partition.type", "BIOS boot partitions are only used with gpt (partition %d)", n+1))
+			}
+			if p.Filesystem != "" || p.MountPoint != "" {
+				errs = append(errs, keyErrorf("partition.filesystem", "BIOS boot partition %d cannot be formatted", n+1))
+			}
+		default:
+			errs = append(errs, keyErrorf("partition.type", "Unknown partition type for partition %d: %v", n+1, p.Type))
+		}
+
+		if p.Size < 0 {
+			errs = append(errs, keyErrorf("partition.size", "Invalid size for partition %d", n+1))
+		} else if p.Size == 0 && n != len(r.Partitions)-1 {
+			// Only the last partition may fill the remaining space
+			errs = append(errs, keyErrorf("partition.size", "Only the last partition may omit the size (partition %d)", n+1))
+		} else {
+			totalSize += p.Size
+		}
+
+		if p.MountPoint == "" {
+			continue
+		}
+		if !filepath.IsAbs(p.MountPoint) {
+			errs = append(errs, keyErrorf("partition.mountpoint", "Invalid mountpoint for partition %d: %v", n+1, p.MountPoint))
+			continue
+		}
+		p.MountPoint = filepath.Clean(p.MountPoint)
+		if mounts[p.MountPoint] {
+			errs = append(errs, keyErrorf("partition.mountpoint", "Duplicate mountpoint: %v", p.MountPoint))
+		}
+		mounts[p.MountPoint] = true
+		if p.MountPoint == "/" {
+			haveRoot = true
+		}
+	}
+
+	if !haveRoot {
+		errs = append(errs, keyErrorf("partition.mountpoint", "No partition is mounted at /"))
+	}
+	if totalSize >= r.Size {
+		errs = append(errs, keyErrorf("size", "Partitions do not fit in a %dMB disk", r.Size))
+	}
+	return errs.errorOrNil()
+}
@@ -0,0 +1,69 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SectionRepo describes a [[repo]] entry, added to the package manager
+// before anything from the packages file
+type SectionRepo struct {
+	Name     string `toml:"name"`     // Name to give the repository
+	URI      string `toml:"uri"`      // URI of the repository
+	Priority int    `toml:"priority"` // Backend specific priority, if supported
+	Key      string `toml:"key"`      // Optional signing key, as a path or URL
+}
+
+// ValidateSectionRepos will determine if the repos are valid, resolving any
+// local signing keys relative to baseDir.
+func ValidateSectionRepos(repos []SectionRepo, baseDir string) error {
+	names := make(map[string]bool)
+	for n := range repos {
+		repo := &repos[n]
+		repo.Name = strings.TrimSpace(repo.Name)
+		repo.URI = strings.TrimSpace(repo.URI)
+		repo.Key = strings.TrimSpace(repo.Key)
+		if repo.Name == "" || strings.ContainsAny(repo.Name, " \t/") {
+			return fmt.Errorf("Invalid name for repo: '%v'", repo.Name)
+		}
+		if names[repo.Name] {
+			return fmt.Errorf("Duplicate repo: %v", repo.Name)
+		}
+		names[repo.Name] = true
+		if repo.URI == "" {
+			return fmt.Errorf("Missing uri for repo: %v", repo.Name)
+		}
+		if repo.Key == "" || strings.Contains(repo.Key, "://") {
+			continue
+		}
+		if !filepath.IsAbs(repo.Key) {
+			key, err := filepath.Abs(filepath.Join(baseDir, repo.Key))
+			if err != nil {
+				return err
+			}
+			repo.Key = key
+		}
+		if _, err := os.Stat(repo.Key); err != nil {
+			return fmt.Errorf("Cannot find key for repo %v: %v", repo.Name, err)
+		}
+	}
+	return nil
+}
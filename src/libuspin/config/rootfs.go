@@ -0,0 +1,71 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A RootfsOutput is the form in which a rootfs image is emitted
+type RootfsOutput string
+
+// An ArchiveCompression is the compression applied to a rootfs tarball
+type ArchiveCompression string
+
+const (
+	// RootfsOutputTarball emits the rootfs as a (compressed) tarball
+	RootfsOutputTarball RootfsOutput = "tarball"
+
+	// RootfsOutputDirectory emits the rootfs as a plain directory tree
+	RootfsOutputDirectory RootfsOutput = "directory"
+)
+
+const (
+	// ArchiveCompressionNone leaves the tarball uncompressed
+	ArchiveCompressionNone ArchiveCompression = "none"
+
+	// ArchiveCompressionGzip produces a .tar.gz
+	ArchiveCompressionGzip ArchiveCompression = "gzip"
+)
+
+// SectionRootfs is the configuration for plain rootfs trees and tarballs
+type SectionRootfs struct {
+	FileName    string             `toml:"filename"`    // The resulting filename or directory
+	Output      RootfsOutput       `toml:"output"`      // tarball or directory, defaults to tarball
+	Compression ArchiveCompression `toml:"compression"` // Compression for tarballs, defaults to gzip
+}
+
+// ValidateSectionRootfs will determine if the configuration is valid for a rootfs
+func ValidateSectionRootfs(r *SectionRootfs) error {
+	r.FileName = strings.TrimSpace(r.FileName)
+	if r.FileName == "" {
+		return errors.New("Invalid filename for rootfs")
+	}
+	switch r.Output {
+	case RootfsOutputTarball, RootfsOutputDirectory:
+	default:
+		return fmt.Errorf("Unknown rootfs output type: %v", r.Output)
+	}
+	switch r.Compression {
+	case ArchiveCompressionNone, ArchiveCompressionGzip:
+	default:
+		return fmt.Errorf("Unknown compression type: %v", r.Compression)
+	}
+	return nil
+}
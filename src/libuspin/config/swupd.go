@@ -0,0 +1,53 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultSwupdContentURL is the Clear Linux update content server
+	DefaultSwupdContentURL = "https://download.clearlinux.org/update"
+
+	// SwupdVersionLatest installs whatever the latest release is
+	SwupdVersionLatest = "latest"
+)
+
+// SectionSwupd is the swupd (Clear Linux) specific configuration
+type SectionSwupd struct {
+	Version    string `toml:"version"`     // Release to pin to, or "latest"
+	ContentURL string `toml:"content_url"` // Content server to install from
+}
+
+// ValidateSectionSwupd will determine if the configuration is valid for swupd
+func ValidateSectionSwupd(s *SectionSwupd) error {
+	s.Version = strings.TrimSpace(s.Version)
+	if s.Version != SwupdVersionLatest {
+		if _, err := strconv.ParseUint(s.Version, 10, 32); err != nil {
+			return fmt.Errorf("Invalid swupd version: '%v'", s.Version)
+		}
+	}
+	s.ContentURL = strings.TrimSpace(s.ContentURL)
+	if s.ContentURL == "" {
+		return errors.New("swupd.content_url cannot be empty")
+	}
+	return nil
+}
@@ -16,6 +16,87 @@
 
 package config
 
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// IsolinuxEntryLocal is the label of the built-in entry to boot from the local
+// drive, which extra entries may not reuse
+const IsolinuxEntryLocal = "local"
+
+// SectionIsolinuxEntry describes an additional [[isolinux.entry]] in the menu,
+// i.e. a memory tester or hardware detection tool
+type SectionIsolinuxEntry struct {
+	Name   string `toml:"name"`   // Unique label for the entry
+	Title  string `toml:"title"`  // Text shown in the menu
+	Kernel string `toml:"kernel"` // Kernel or .c32 module to load
+	Append string `toml:"append"` // Arguments passed to the kernel or module
+}
+
 // SectionIsolinux describes the [isolinux] portion of a spin file
 type SectionIsolinux struct {
+	Template   string                 `toml:"template"`   // isolinux.cfg template, relative to the .spin file
+	Background string                 `toml:"background"` // Splash image, relative to the .spin file
+	Timeout    int                    `toml:"timeout"`    // Menu timeout in 1/10th seconds (default 50)
+	Entries    []SectionIsolinuxEntry `toml:"entry"`      // Additional menu entries
+
+	TemplateData string `toml:"-"` // Contents of the validated template
+}
+
+// ValidateSectionIsolinux will determine if the isolinux configuration is valid,
+// loading and parsing the template now so that errors are caught before any
+// build work is done. Paths are resolved relative to baseDir. The template is
+// executed against sample data by boot.ValidateIsolinuxTemplate, as the data
+// is only known to the boot package.
+func ValidateSectionIsolinux(i *SectionIsolinux, baseDir string) error {
+	if i.Timeout < 0 {
+		return errors.New("Invalid timeout for isolinux")
+	}
+
+	if i.Template = strings.TrimSpace(i.Template); i.Template != "" {
+		if !filepath.IsAbs(i.Template) {
+			i.Template = filepath.Join(baseDir, i.Template)
+		}
+		data, err := ioutil.ReadFile(i.Template)
+		if err != nil {
+			return err
+		}
+		if _, err := template.New("isolinux").Parse(string(data)); err != nil {
+			return fmt.Errorf("Invalid isolinux template: %v", err)
+		}
+		i.TemplateData = string(data)
+	}
+
+	if i.Background = strings.TrimSpace(i.Background); i.Background != "" {
+		if !filepath.IsAbs(i.Background) {
+			i.Background = filepath.Join(baseDir, i.Background)
+		}
+		if _, err := os.Stat(i.Background); err != nil {
+			return err
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, entry := range i.Entries {
+		if entry.Name == "" || strings.ContainsAny(entry.Name, " \t") {
+			return fmt.Errorf("Invalid name for isolinux entry: '%v'", entry.Name)
+		}
+		if entry.Name == BootEntryDefault || entry.Name == IsolinuxEntryLocal || strings.HasPrefix(entry.Name, "kernel-") {
+			return fmt.Errorf("Reserved name for isolinux entry: %v", entry.Name)
+		}
+		if names[entry.Name] {
+			return fmt.Errorf("Duplicate isolinux entry: %v", entry.Name)
+		}
+		names[entry.Name] = true
+		if strings.TrimSpace(entry.Kernel) == "" {
+			return fmt.Errorf("Missing kernel for isolinux entry: %v", entry.Name)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,91 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// MinRootfsSize is the smallest rootfs_size accepted, in megabytes
+	MinRootfsSize = 100
+
+	// MaxRootfsSize is the largest rootfs_size accepted, in megabytes (1TiB)
+	MaxRootfsSize = 1024 * 1024
+)
+
+// SupportedFilesystems are the rootfs_format values for loopback rootfs images
+var SupportedFilesystems = []string{"ext2", "ext3", "ext4", "btrfs", "xfs", "f2fs"}
+
+// validateRootfsImage will check the size and format of a loopback rootfs
+// image, normalising the format.
+func validateRootfsImage(size int, format *string) KeyErrors {
+	var errs KeyErrors
+	if size < MinRootfsSize || size > MaxRootfsSize {
+		errs = append(errs, keyErrorf("rootfs_size", "Must be between %v and %v megabytes, not %v", MinRootfsSize, MaxRootfsSize, size))
+	}
+	*format = strings.TrimSpace(*format)
+	supported := false
+	for _, fs := range SupportedFilesystems {
+		if *format == fs {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		errs = append(errs, keyErrorf("rootfs_format", "Unsupported filesystem '%v', expected one of: %v", *format, strings.Join(SupportedFilesystems, ", ")))
+	}
+	return errs
+}
+
+// validateLoaders will check that every bootloader is known, and that none is
+// listed twice.
+func validateLoaders(loaders []LoaderType) KeyErrors {
+	var errs KeyErrors
+	seen := make(map[LoaderType]bool)
+	for _, l := range loaders {
+		switch l {
+		case LoaderTypeSyslinux, LoaderTypeSystemdBoot, LoaderTypeGrub2:
+		default:
+			errs = append(errs, keyErrorf("bootloaders", "Unknown bootloader '%v'", l))
+			continue
+		}
+		if seen[l] {
+			errs = append(errs, keyErrorf("bootloaders", "Duplicate bootloader '%v'", l))
+		}
+		seen[l] = true
+	}
+	return errs
+}
+
+// containsPath reports whether child is parent, or lies beneath it. Relative
+// paths are taken from the current directory.
+func containsPath(parent, child string) bool {
+	parent, err := filepath.Abs(parent)
+	if err != nil {
+		return true
+	}
+	if child, err = filepath.Abs(child); err != nil {
+		return true
+	}
+	rel, err := filepath.Rel(parent, child)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,106 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"fmt"
+	"libuspin/spec"
+	"os"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// builtinVars returns the variables that are always available for expansion,
+// unless overridden in [vars] or from the command line:
+//
+//   - date is the build date as YYYYMMDD, from SOURCE_DATE_EPOCH if set
+//   - git_rev is the short revision of the git checkout containing the spin
+//     file, if there is one
+func builtinVars(baseDir string) (map[string]string, error) {
+	now := time.Now()
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		secs, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid SOURCE_DATE_EPOCH: %v", epoch)
+		}
+		now = time.Unix(secs, 0)
+	}
+	vars := map[string]string{
+		"date": now.UTC().Format("20060102"),
+	}
+	cmd := exec.Command("git", "rev-parse", "--short", "HEAD")
+	cmd.Dir = baseDir
+	if out, err := cmd.Output(); err == nil {
+		vars["git_rev"] = strings.TrimSpace(string(out))
+	}
+	return vars, nil
+}
+
+// expandVars will expand ${name} references in every string within the
+// configuration, except for the variables themselves.
+func (i *ImageConfiguration) expandVars() error {
+	vars := i.Vars
+	i.Vars = nil
+	defer func() {
+		i.Vars = vars
+	}()
+	return expandValue(reflect.ValueOf(i).Elem(), vars)
+}
+
+// expandValue will recursively expand the strings within rv
+func expandValue(rv reflect.Value, vars map[string]string) error {
+	switch rv.Kind() {
+	case reflect.String:
+		s, err := spec.ExpandVars(rv.String(), vars)
+		if err != nil {
+			return err
+		}
+		rv.SetString(s)
+	case reflect.Struct:
+		for n := 0; n < rv.NumField(); n++ {
+			// Only expand the exported fields decoded from the spin file
+			field := rv.Type().Field(n)
+			if field.PkgPath != "" || field.Tag.Get("toml") == "-" {
+				continue
+			}
+			if err := expandValue(rv.Field(n), vars); err != nil {
+				return fmt.Errorf("%v: %v", field.Tag.Get("toml"), err)
+			}
+		}
+	case reflect.Slice:
+		for n := 0; n < rv.Len(); n++ {
+			if err := expandValue(rv.Index(n), vars); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, key := range rv.MapKeys() {
+			if rv.MapIndex(key).Kind() != reflect.String {
+				continue
+			}
+			s, err := spec.ExpandVars(rv.MapIndex(key).String(), vars)
+			if err != nil {
+				return err
+			}
+			rv.SetMapIndex(key, reflect.ValueOf(s).Convert(rv.Type().Elem()))
+		}
+	}
+	return nil
+}
@@ -24,10 +24,12 @@ package libuspin
 import (
 	"errors"
 	"fmt"
-	"github.com/solus-project/libosdev/pkg"
+	"libuspin/boot"
 	"libuspin/config"
+	"libuspin/packager"
 	"libuspin/spec"
 	"path/filepath"
+	"runtime"
 	"strings"
 )
 
@@ -44,11 +46,33 @@ type ImageSpec struct {
 	Stack   *spec.OpStack
 	Config  *config.ImageConfiguration
 	BaseDir string // Used to join filename paths relative to the .spin file, i.e. packages
+
+	PackageFiles []string // Every packages file parsed, including any includes
+}
+
+// hostArch returns the host architecture as distributions name it
+func hostArch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	case "386":
+		return "i686"
+	case "arm64":
+		return "aarch64"
+	default:
+		return runtime.GOARCH
+	}
 }
 
 // NewImageSpec is a factory function to load a .spin file with it's associated
 // Packages file prepped into a usable stack.
 func NewImageSpec(spinFile string) (*ImageSpec, error) {
+	return NewImageSpecWithOptions(spinFile, &config.Options{})
+}
+
+// NewImageSpecWithOptions will load the .spin file exactly like NewImageSpec,
+// passing the options to the configuration loader.
+func NewImageSpecWithOptions(spinFile string, opts *config.Options) (*ImageSpec, error) {
 	is := &ImageSpec{}
 
 	if !strings.HasSuffix(spinFile, ".spin") {
@@ -56,11 +80,16 @@ func NewImageSpec(spinFile string) (*ImageSpec, error) {
 	}
 
 	// Try loading the configuration first
-	conf, err := config.New(spinFile)
+	conf, err := config.NewWithOptions(spinFile, opts)
 	if err != nil {
 		return nil, err
 	}
 
+	// The isolinux template can only be checked against the loader's data
+	if err := boot.ValidateIsolinuxTemplate(conf); err != nil {
+		return nil, err
+	}
+
 	// Grab the base directory from the .spin file
 	is.BaseDir, err = filepath.Abs(filepath.Dir(spinFile))
 	if err != nil {
@@ -69,21 +98,63 @@ func NewImageSpec(spinFile string) (*ImageSpec, error) {
 
 	// Load packages file relative to the spin file
 	parser := spec.NewParser()
-	pkgsFile := filepath.Join(is.BaseDir, conf.Image.Packages)
+	parser.RejectPackages = conf.Image.PackageManager == config.PackageManagerSwupd
+	parser.RejectExcludes = conf.Image.PackageManager == config.PackageManagerEopkg
+	parser.RejectRepos = conf.Image.Strict
+	parser.Vars = map[string]string{
+		"arch":            hostArch(),
+		"type":            string(conf.Image.Type),
+		"package_manager": string(conf.Image.PackageManager),
+	}
+	for k, v := range conf.Vars {
+		parser.Vars[k] = v
+	}
+	pkgsFile := conf.Image.Packages
+	if !filepath.IsAbs(pkgsFile) {
+		pkgsFile = filepath.Join(is.BaseDir, pkgsFile)
+	}
 	if err = parser.Parse(pkgsFile); err != nil {
 		return nil, err
 	}
+	is.PackageFiles = parser.Files
+
+	// Repos from the .spin file always come first
+	if len(conf.Repos) > 0 {
+		repos := &spec.OpSet{}
+		for _, repo := range conf.Repos {
+			repos.Ops = append(repos.Ops, &spec.OpRepo{
+				RepoName: repo.Name,
+				RepoURI:  repo.URI,
+				Priority: repo.Priority,
+				Key:      repo.Key,
+			})
+		}
+		parser.Stack.Blocks = append([]*spec.OpSet{repos}, parser.Stack.Blocks...)
+	}
 
-	// Return new ImageSpec with our own copies
-	return &ImageSpec{
-		Stack:  parser.Stack,
-		Config: conf,
-	}, nil
+	is.Stack = parser.Stack
+	is.Config = conf
+	return is, nil
+}
+
+// verifyVersions will ensure the installed version of each package satisfies
+// its constraint, so that we never silently produce the wrong media.
+func verifyVersions(manager packager.Manager, pkgs []*spec.OpPackage) error {
+	for _, pkg := range pkgs {
+		version, err := manager.GetInstalledVersion(pkg.Name)
+		if err != nil {
+			return err
+		}
+		if err := pkg.Constraint.Verify(pkg.Name, version, manager.CompareVersions); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // ApplyOperations will apply the given spec operations against the package
 // manager instance
-func ApplyOperations(manager pkg.Manager, ops []spec.Operation) error {
+func ApplyOperations(manager packager.Manager, ops []spec.Operation) error {
 	if len(ops) == 0 {
 		return ErrNotEnoughOps
 	}
@@ -92,7 +163,11 @@ func ApplyOperations(manager pkg.Manager, ops []spec.Operation) error {
 		// Insert one repo at a time
 		for _, op := range ops {
 			repo := op.(*spec.OpRepo)
-			if err := manager.AddRepo(repo.RepoName, repo.RepoURI); err != nil {
+			opts := packager.RepoOptions{
+				Priority: repo.Priority,
+				Key:      repo.Key,
+			}
+			if err := manager.AddRepoOptions(repo.RepoName, repo.RepoURI, opts); err != nil {
 				return err
 			}
 		}
@@ -108,11 +183,41 @@ func ApplyOperations(manager pkg.Manager, ops []spec.Operation) error {
 	case *spec.OpPackage:
 		// Group/component goes in bulk
 		ignoreSafety := ops[0].(*spec.OpPackage).IgnoreSafety
+		var names []string
+		var versions []packager.PackageVersion
+		var constrained []*spec.OpPackage
+		for _, op := range ops {
+			pkg := op.(*spec.OpPackage)
+			names = append(names, pkg.Name)
+			version := packager.PackageVersion{Name: pkg.Name}
+			if pkg.Constraint != nil {
+				constrained = append(constrained, pkg)
+				if pkg.Constraint.Op == "==" {
+					version.Version = pkg.Constraint.Version
+				}
+			}
+			versions = append(versions, version)
+		}
+		if len(constrained) == 0 {
+			return manager.InstallPackages(ignoreSafety, names)
+		}
+		if err := manager.InstallPackageVersions(ignoreSafety, versions); err != nil {
+			return err
+		}
+		return verifyVersions(manager, constrained)
+	case *spec.OpRemove:
+		ignoreSafety := ops[0].(*spec.OpRemove).IgnoreSafety
+		var names []string
+		for _, op := range ops {
+			names = append(names, op.(*spec.OpRemove).Name)
+		}
+		return manager.RemovePackages(ignoreSafety, names)
+	case *spec.OpExclude:
 		var names []string
 		for _, op := range ops {
-			names = append(names, op.(*spec.OpPackage).Name)
+			names = append(names, op.(*spec.OpExclude).Name)
 		}
-		return manager.InstallPackages(ignoreSafety, names)
+		return manager.ExcludePackages(names)
 	default:
 		return ErrUnknownOperation
 	}
@@ -17,15 +17,84 @@
 package libuspin
 
 import (
+	"errors"
+	"libuspin/packager"
+	"libuspin/spec"
 	"testing"
 )
 
 const (
 	minimalFile = "../../testdata/minimal.spin"
+	reposFile   = "../../testdata/repos.spin"
+	strictFile  = "../../testdata/strict.spin"
+	editionFile = "../../testdata/editions/budgie.spin"
 )
 
 func TestImageSpec(t *testing.T) {
 	if _, err := NewImageSpec(minimalFile); err != nil {
 		t.Fatalf("Cannot load image spec: %v", err)
 	}
+	// Packages file is inherited from the base spin file
+	if _, err := NewImageSpec(editionFile); err != nil {
+		t.Fatalf("Cannot load extending image spec: %v", err)
+	}
+}
+
+func TestImageSpecRepos(t *testing.T) {
+	is, err := NewImageSpec(reposFile)
+	if err != nil {
+		t.Fatalf("Cannot load image spec: %v", err)
+	}
+	repo, ok := is.Stack.Blocks[0].Ops[0].(*spec.OpRepo)
+	if !ok {
+		t.Fatalf("Repos from the .spin file should come first")
+	}
+	if repo.RepoName != "Solus" || repo.Priority != 10 {
+		t.Fatalf("Invalid repo: %v %v", repo.RepoName, repo.Priority)
+	}
+	if _, err := NewImageSpec(strictFile); err == nil {
+		t.Fatalf("Repos in packages file should fail in strict mode")
+	}
+}
+
+// fakeManager records installs and reports fixed installed versions
+type fakeManager struct {
+	packager.Manager
+	installed map[string]string
+	requested []packager.PackageVersion
+}
+
+func (f *fakeManager) InstallPackageVersions(ignoreSafety bool, packages []packager.PackageVersion) error {
+	f.requested = append(f.requested, packages...)
+	return nil
+}
+
+func (f *fakeManager) GetInstalledVersion(name string) (string, error) {
+	if v, ok := f.installed[name]; ok {
+		return v, nil
+	}
+	return "", errors.New("Not installed")
+}
+
+func (f *fakeManager) CompareVersions(a, b string) int {
+	return spec.CompareVersions(a, b)
+}
+
+func TestApplyVersions(t *testing.T) {
+	ops := []spec.Operation{
+		&spec.OpPackage{Name: "nano", Constraint: &spec.VersionConstraint{Op: "==", Version: "2.7.1-55"}},
+		&spec.OpPackage{Name: "kernel", Constraint: &spec.VersionConstraint{Op: ">=", Version: "4.8"}},
+	}
+	f := &fakeManager{installed: map[string]string{"nano": "2.7.1-55", "kernel": "4.8.12-11"}}
+	if err := ApplyOperations(f, ops); err != nil {
+		t.Fatalf("Satisfied versions should apply: %v", err)
+	}
+	if f.requested[0].Version != "2.7.1-55" || f.requested[1].Version != "" {
+		t.Fatalf("Wrong versions requested: %v", f.requested)
+	}
+
+	f.installed["kernel"] = "4.4.38-30"
+	if err := ApplyOperations(f, ops); err == nil {
+		t.Fatalf("Unsatisfied version should fail the build")
+	}
 }
@@ -0,0 +1,339 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package packager
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/solus-project/libosdev/commands"
+	"github.com/solus-project/libosdev/disk"
+	"io/ioutil"
+	"libuspin/config"
+	"libuspin/spec"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// aptPolicyRcd prevents any services from being started within the chroot
+	aptPolicyRcd = "#!/bin/sh\nexit 101\n"
+
+	// aptEnv is prefixed to every apt-get invocation in the chroot
+	aptEnv = "env DEBIAN_FRONTEND=noninteractive DEBCONF_NONINTERACTIVE_SEEN=true"
+)
+
+// AptManager populates a Debian-family rootfs. The first repository is used
+// to debootstrap the configured suite, and all further repositories are added
+// to sources.list.d for apt-get to use within the chroot.
+type AptManager struct {
+	conf *config.SectionApt
+	root string
+
+	bootstrapped bool     // Whether debootstrap has run yet
+	dirty        bool     // Whether apt-get update is needed
+	mounts       []string // Mounted API filesystems, in mount order
+}
+
+// NewAptManager will return a new AptManager for the given configuration
+func NewAptManager(conf *config.SectionApt) *AptManager {
+	return &AptManager{
+		conf: conf,
+	}
+}
+
+// Init will ensure debootstrap is available on the host
+func (a *AptManager) Init() error {
+	if _, err := exec.LookPath("debootstrap"); err != nil {
+		return errors.New("Cannot find debootstrap on the host")
+	}
+	return nil
+}
+
+// InitRoot will just store the root, as nothing can happen until we know
+// which mirror to debootstrap from.
+func (a *AptManager) InitRoot(root string) error {
+	a.root = root
+	return nil
+}
+
+// AddRepo will debootstrap from the first repository, and add any others to
+// sources.list.d.
+func (a *AptManager) AddRepo(name, uri string) error {
+	return a.AddRepoOptions(name, uri, RepoOptions{})
+}
+
+// splitSource will split a repository uri into the mirror URL and the suite
+// and components that follow it, if any, ensuring the mirror is a valid URL.
+func splitSource(uri string) (string, []string, error) {
+	fields := strings.Fields(uri)
+	if len(fields) == 0 {
+		return "", nil, errors.New("Missing apt repository URI")
+	}
+	u, err := url.Parse(fields[0])
+	if err != nil || u.Scheme == "" || (u.Host == "" && u.Scheme != "file") {
+		return "", nil, fmt.Errorf("Invalid apt mirror URL: %v", fields[0])
+	}
+	return fields[0], fields[1:], nil
+}
+
+// AddRepoOptions will debootstrap from the first repository, and add any
+// others to sources.list.d. The first repository must be a bare mirror URL,
+// as the suite and components to debootstrap come from the [apt] section.
+// For the others, a uri containing spaces is treated as a complete
+// "uri suite components" line, otherwise the configured suite is used. Local
+// keys are installed into trusted.gpg.d, and the priority is written as an
+// apt pin for the repository host.
+func (a *AptManager) AddRepoOptions(name, uri string, opts RepoOptions) error {
+	mirror, rest, err := splitSource(uri)
+	if err != nil {
+		return err
+	}
+
+	if strings.Contains(opts.Key, "://") {
+		log.WithFields(log.Fields{
+			"repo": name,
+		}).Warning("apt only supports local signing keys, ignoring")
+		opts.Key = ""
+	}
+
+	if !a.bootstrapped {
+		if len(rest) > 0 {
+			return fmt.Errorf("The first apt repository '%v' is used to debootstrap and must be a bare mirror URL, set the suite and components in [apt] instead: %v", name, uri)
+		}
+		if err := a.bootstrap(mirror, opts.Key); err != nil {
+			return err
+		}
+	} else {
+		line := strings.Join(append([]string{mirror}, rest...), " ")
+		if len(rest) == 0 {
+			line = fmt.Sprintf("%s %s %s", mirror, a.conf.Suite, strings.Join(a.conf.Components, " "))
+		}
+		path := filepath.Join(a.root, "etc", "apt", "sources.list.d", name+".list")
+		if err := ioutil.WriteFile(path, []byte("deb "+line+"\n"), 00644); err != nil {
+			return err
+		}
+		a.dirty = true
+	}
+
+	if opts.Key != "" {
+		ext := ".gpg"
+		if strings.HasSuffix(opts.Key, ".asc") {
+			ext = ".asc"
+		}
+		if err := disk.CopyFile(opts.Key, filepath.Join(a.root, "etc", "apt", "trusted.gpg.d", name+ext)); err != nil {
+			return err
+		}
+	}
+
+	if opts.Priority != 0 {
+		return a.writePin(name, mirror, opts.Priority)
+	}
+	return nil
+}
+
+// writePin will pin every package from the mirror host to the priority
+func (a *AptManager) writePin(name, mirror string, priority int) error {
+	u, err := url.Parse(mirror)
+	if err != nil {
+		return err
+	}
+	contents := fmt.Sprintf("Package: *\nPin: origin \"%s\"\nPin-Priority: %d\n", u.Hostname(), priority)
+	path := filepath.Join(a.root, "etc", "apt", "preferences.d", name+".pref")
+	if err := os.MkdirAll(filepath.Dir(path), 00755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(contents), 00644)
+}
+
+// bootstrap will debootstrap the suite from the mirror, and then prepare the
+// chroot for apt-get usage
+func (a *AptManager) bootstrap(mirror, keyring string) error {
+	args := []string{
+		"--components=" + strings.Join(a.conf.Components, ","),
+	}
+	if a.conf.Variant != "" {
+		args = append(args, "--variant="+a.conf.Variant)
+	}
+	if keyring != "" {
+		args = append(args, "--keyring="+keyring)
+	}
+	args = append(args, a.conf.Suite, a.root, mirror)
+
+	log.WithFields(log.Fields{
+		"suite":  a.conf.Suite,
+		"mirror": mirror,
+	}).Info("Bootstrapping rootfs")
+
+	if err := commands.ExecStdoutArgs("debootstrap", args); err != nil {
+		return err
+	}
+	a.bootstrapped = true
+
+	policy := filepath.Join(a.root, "usr", "sbin", "policy-rc.d")
+	if err := ioutil.WriteFile(policy, []byte(aptPolicyRcd), 00755); err != nil {
+		return err
+	}
+	return a.mountAPI()
+}
+
+// mountAPI will mount the API filesystems needed by maintainer scripts
+func (a *AptManager) mountAPI() error {
+	mman := disk.GetMountManager()
+	for _, m := range []struct {
+		source string
+		target string
+		fs     string
+	}{
+		{"proc", "proc", "proc"},
+		{"sysfs", "sys", "sysfs"},
+		{"devpts", "dev/pts", "devpts"},
+	} {
+		target := filepath.Join(a.root, m.target)
+		if err := os.MkdirAll(target, 00755); err != nil {
+			return err
+		}
+		if err := mman.Mount(m.source, target, m.fs); err != nil {
+			return err
+		}
+		a.mounts = append(a.mounts, target)
+	}
+	return nil
+}
+
+// aptGet will run apt-get non-interactively within the chroot
+func (a *AptManager) aptGet(args ...string) error {
+	if !a.bootstrapped {
+		return errors.New("No repository has been added to bootstrap from")
+	}
+	if a.dirty {
+		a.dirty = false
+		if err := commands.ChrootExec(a.root, aptEnv+" apt-get update"); err != nil {
+			return err
+		}
+	}
+	cmd := fmt.Sprintf("%s apt-get -y --no-install-recommends %s", aptEnv, strings.Join(args, " "))
+	return commands.ChrootExec(a.root, cmd)
+}
+
+// InstallGroups will install tasksel tasks or metapackages, depending on the
+// configured group mode. ignoreSafety has no meaning for apt.
+func (a *AptManager) InstallGroups(ignoreSafety bool, groups []string) error {
+	args := []string{"install"}
+	for _, group := range groups {
+		if a.conf.Groups == config.AptGroupModeTask {
+			group += "^"
+		}
+		args = append(args, group)
+	}
+	return a.aptGet(args...)
+}
+
+// InstallPackages will install the packages with apt-get. ignoreSafety has
+// no meaning for apt.
+func (a *AptManager) InstallPackages(ignoreSafety bool, packages []string) error {
+	return a.aptGet(append([]string{"install"}, packages...)...)
+}
+
+// InstallPackageVersions will install the packages, using name=version for
+// those with an exact version.
+func (a *AptManager) InstallPackageVersions(ignoreSafety bool, packages []PackageVersion) error {
+	return a.InstallPackages(ignoreSafety, pinnedNames(packages, "="))
+}
+
+// GetInstalledVersion will query the dpkg database in the root
+func (a *AptManager) GetInstalledVersion(name string) (string, error) {
+	admindir := filepath.Join(a.root, "var", "lib", "dpkg")
+	out, err := exec.Command("dpkg-query", "--admindir="+admindir, "-W", "-f", "${Version}", name).Output()
+	if err != nil || len(out) == 0 {
+		return "", fmt.Errorf("Package is not installed: %v", name)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// CompareVersions will compare the versions as dpkg does, respecting epochs
+// and '~' pre-releases
+func (a *AptManager) CompareVersions(x, y string) int {
+	return spec.CompareDebianVersions(x, y)
+}
+
+// RemovePackages will purge the packages from the root. ignoreSafety will
+// allow removal of essential packages.
+func (a *AptManager) RemovePackages(ignoreSafety bool, packages []string) error {
+	args := []string{"purge"}
+	if ignoreSafety {
+		args = append(args, "--allow-remove-essential")
+	}
+	return a.aptGet(append(args, packages...)...)
+}
+
+// ExcludePackages will pin the packages with a negative priority so that
+// apt will never install them. The pin is removed again in FinalizeRoot.
+func (a *AptManager) ExcludePackages(packages []string) error {
+	if !a.bootstrapped {
+		return errors.New("No repository has been added to bootstrap from")
+	}
+	var contents string
+	for _, p := range packages {
+		contents += fmt.Sprintf("Package: %s\nPin: release *\nPin-Priority: -1\n\n", p)
+	}
+	path := a.excludePinPath()
+	if err := os.MkdirAll(filepath.Dir(path), 00755); err != nil {
+		return err
+	}
+	fi, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 00644)
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+	_, err = fi.WriteString(contents)
+	return err
+}
+
+// excludePinPath returns the path to the pin file for excluded packages
+func (a *AptManager) excludePinPath() string {
+	return filepath.Join(a.root, "etc", "apt", "preferences.d", "uspin-exclude.pref")
+}
+
+// FinalizeRoot will clean the package caches and allow services to start
+// again once the image is booted. Exclusions only apply to the build, so the
+// pin file is removed rather than shipped in the image.
+func (a *AptManager) FinalizeRoot() error {
+	if err := a.aptGet("clean"); err != nil {
+		return err
+	}
+	if err := os.Remove(a.excludePinPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Remove(filepath.Join(a.root, "usr", "sbin", "policy-rc.d"))
+}
+
+// Cleanup will unmount the API filesystems from the chroot
+func (a *AptManager) Cleanup() error {
+	var err error
+	mman := disk.GetMountManager()
+	for i := len(a.mounts) - 1; i >= 0; i-- {
+		if e := mman.Unmount(a.mounts[i]); e != nil && err == nil {
+			err = e
+		}
+	}
+	a.mounts = nil
+	return err
+}
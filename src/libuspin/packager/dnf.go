@@ -0,0 +1,174 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package packager
+
+import (
+	"fmt"
+	"github.com/solus-project/libosdev/commands"
+	"io/ioutil"
+	"libuspin/config"
+	"libuspin/spec"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// DnfManager drives dnf (or yum) from the host with --installroot to
+// populate a Fedora-family rootfs
+type DnfManager struct {
+	tool string // dnf or yum
+	conf *config.SectionDnf
+	root string
+
+	excludes []string // Packages excluded from every following operation
+}
+
+// NewDnfManager will return a new DnfManager using the given host tool
+func NewDnfManager(tool string, conf *config.SectionDnf) *DnfManager {
+	return &DnfManager{
+		tool: tool,
+		conf: conf,
+	}
+}
+
+// Init will ensure the host tooling is available
+func (d *DnfManager) Init() error {
+	for _, tool := range []string{d.tool, "rpm"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return fmt.Errorf("Cannot find %v on the host", tool)
+		}
+	}
+	return nil
+}
+
+// reposDir returns the directory within the root that holds .repo files
+func (d *DnfManager) reposDir() string {
+	return filepath.Join(d.root, "etc", "yum.repos.d")
+}
+
+// run will execute the tool against the installroot, using only the repos
+// that have been added to the root.
+func (d *DnfManager) run(args ...string) error {
+	cmd := []string{
+		"--installroot=" + d.root,
+		"--releasever=" + d.conf.ReleaseVer,
+		"--setopt=reposdir=" + d.reposDir(),
+		"-y",
+	}
+	if len(d.excludes) > 0 {
+		cmd = append(cmd, "--exclude="+strings.Join(d.excludes, ","))
+	}
+	return commands.ExecStdoutArgs(d.tool, append(cmd, args...))
+}
+
+// InitRoot will set up the rpm database within the root
+func (d *DnfManager) InitRoot(root string) error {
+	d.root = root
+	if err := os.MkdirAll(d.reposDir(), 00755); err != nil {
+		return err
+	}
+	return commands.ExecStdoutArgs("rpm", []string{"--root", root, "--initdb"})
+}
+
+// AddRepo will write a .repo file for the repository into the root
+func (d *DnfManager) AddRepo(name, uri string) error {
+	return d.AddRepoOptions(name, uri, RepoOptions{})
+}
+
+// AddRepoOptions will write a .repo file for the repository into the root.
+// URIs containing "metalink" are treated as a metalink rather than a baseurl,
+// and signature checking is only enabled when a key is given.
+func (d *DnfManager) AddRepoOptions(name, uri string, opts RepoOptions) error {
+	key := "baseurl"
+	if strings.Contains(uri, "metalink") {
+		key = "metalink"
+	}
+	contents := fmt.Sprintf("[%s]\nname=%s\n%s=%s\nenabled=1\n", name, name, key, uri)
+	if opts.Priority != 0 {
+		contents += fmt.Sprintf("priority=%d\n", opts.Priority)
+	}
+	if opts.Key == "" {
+		contents += "gpgcheck=0\n"
+	} else {
+		gpgkey := opts.Key
+		if !strings.Contains(gpgkey, "://") {
+			gpgkey = "file://" + gpgkey
+		}
+		contents += fmt.Sprintf("gpgcheck=1\ngpgkey=%s\n", gpgkey)
+	}
+	return ioutil.WriteFile(filepath.Join(d.reposDir(), name+".repo"), []byte(contents), 00644)
+}
+
+// InstallGroups will install the dnf groups into the root. ignoreSafety
+// has no meaning for dnf.
+func (d *DnfManager) InstallGroups(ignoreSafety bool, groups []string) error {
+	return d.run(append([]string{"groupinstall"}, groups...)...)
+}
+
+// InstallPackages will install the packages into the root. ignoreSafety
+// has no meaning for dnf.
+func (d *DnfManager) InstallPackages(ignoreSafety bool, packages []string) error {
+	return d.run(append([]string{"install"}, packages...)...)
+}
+
+// InstallPackageVersions will install the packages, using name-version for
+// those with an exact version.
+func (d *DnfManager) InstallPackageVersions(ignoreSafety bool, packages []PackageVersion) error {
+	return d.InstallPackages(ignoreSafety, pinnedNames(packages, "-"))
+}
+
+// GetInstalledVersion will query the rpm database in the root
+func (d *DnfManager) GetInstalledVersion(name string) (string, error) {
+	out, err := exec.Command("rpm", "--root", d.root, "-q", "--qf", "%{VERSION}-%{RELEASE}", name).Output()
+	if err != nil {
+		return "", fmt.Errorf("Package is not installed: %v", name)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// CompareVersions will compare the versions as rpm does
+func (d *DnfManager) CompareVersions(a, b string) int {
+	return spec.CompareVersions(a, b)
+}
+
+// RemovePackages will remove the packages from the root. ignoreSafety will
+// allow removal of dnf's protected packages.
+func (d *DnfManager) RemovePackages(ignoreSafety bool, packages []string) error {
+	args := []string{"remove"}
+	if ignoreSafety {
+		args = append(args, "--setopt=protected_packages=")
+	}
+	return d.run(append(args, packages...)...)
+}
+
+// ExcludePackages will exclude the packages from all following operations,
+// which is every install as exclusions are applied first.
+func (d *DnfManager) ExcludePackages(packages []string) error {
+	d.excludes = append(d.excludes, packages...)
+	return nil
+}
+
+// FinalizeRoot will clean the package caches out of the root
+func (d *DnfManager) FinalizeRoot() error {
+	return d.run("clean", "all")
+}
+
+// Cleanup has nothing to do, as dnf runs entirely from the host
+func (d *DnfManager) Cleanup() error {
+	return nil
+}
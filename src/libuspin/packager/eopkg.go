@@ -0,0 +1,125 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package packager
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/solus-project/libosdev/commands"
+	"github.com/solus-project/libosdev/pkg"
+	"io/ioutil"
+	"libuspin/spec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// eopkgVersionRegex matches the version-release suffix of the installed
+	// package directories
+	eopkgVersionRegex = regexp.MustCompile(`^[^-]+-[0-9]+$`)
+)
+
+// EopkgManager wraps the libosdev eopkg implementation to provide the
+// additional Manager operations
+type EopkgManager struct {
+	pkg.Manager
+
+	root string
+}
+
+// NewEopkgManager will return a new EopkgManager
+func NewEopkgManager() (*EopkgManager, error) {
+	m, err := pkg.NewManager(pkg.PackageManagerEopkg)
+	if err != nil {
+		return nil, err
+	}
+	return &EopkgManager{Manager: m}, nil
+}
+
+// AddRepoOptions will add the repository, ignoring the options which eopkg
+// has no support for. Repositories are used in the order they are added.
+func (e *EopkgManager) AddRepoOptions(name, uri string, opts RepoOptions) error {
+	if opts.Priority != 0 || opts.Key != "" {
+		log.WithFields(log.Fields{
+			"repo": name,
+		}).Warning("eopkg does not support repo priority or key, ignoring")
+	}
+	return e.AddRepo(name, uri)
+}
+
+// InitRoot will store the root for our own operations before passing it on
+func (e *EopkgManager) InitRoot(root string) error {
+	e.root = root
+	return e.Manager.InitRoot(root)
+}
+
+// remove will run eopkg rm within the root with the given flags
+func (e *EopkgManager) remove(flags string, packages []string) error {
+	cmd := "eopkg rm -y " + flags + " " + strings.Join(packages, " ")
+	return commands.ChrootExec(e.root, cmd)
+}
+
+// RemovePackages will remove the packages, and any reverse dependencies,
+// from the root
+func (e *EopkgManager) RemovePackages(ignoreSafety bool, packages []string) error {
+	flags := ""
+	if ignoreSafety {
+		flags = "--ignore-safety"
+	}
+	return e.remove(flags, packages)
+}
+
+// ExcludePackages is always an error, as eopkg cannot prevent a package
+// from being pulled in as a dependency
+func (e *EopkgManager) ExcludePackages(packages []string) error {
+	return errors.New("eopkg cannot exclude packages")
+}
+
+// InstallPackageVersions will install the packages. eopkg can only install
+// the version in the repository, so the versions are left to be verified.
+func (e *EopkgManager) InstallPackageVersions(ignoreSafety bool, packages []PackageVersion) error {
+	var names []string
+	for _, p := range packages {
+		names = append(names, p.Name)
+	}
+	return e.InstallPackages(ignoreSafety, names)
+}
+
+// GetInstalledVersion will find the version-release of the package from the
+// eopkg database within the root.
+func (e *EopkgManager) GetInstalledVersion(name string) (string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(e.root, "var", "lib", "eopkg", "package"))
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), name+"-") {
+			continue
+		}
+		if version := entry.Name()[len(name)+1:]; eopkgVersionRegex.MatchString(version) {
+			return version, nil
+		}
+	}
+	return "", fmt.Errorf("Package is not installed: %v", name)
+}
+
+// CompareVersions will compare the versions segment by segment
+func (e *EopkgManager) CompareVersions(a, b string) int {
+	return spec.CompareVersions(a, b)
+}
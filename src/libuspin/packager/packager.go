@@ -0,0 +1,97 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package packager provides the package manager backends used to populate
+// the rootfs of an image
+package packager
+
+import (
+	"fmt"
+	"github.com/solus-project/libosdev/pkg"
+	"libuspin/config"
+)
+
+// RepoOptions are the optional extras for a repository from the .spin file
+type RepoOptions struct {
+	Priority int    // Backend specific priority, 0 for the default
+	Key      string // Signing key as an absolute path or URL, if any
+}
+
+// A PackageVersion is a package to install at a specific version
+type PackageVersion struct {
+	Name    string // Name of the package
+	Version string // Exact version to install, or empty for the latest
+}
+
+// A Manager extends the libosdev pkg.Manager with the additional operations
+// that USpin supports
+type Manager interface {
+	pkg.Manager
+
+	// AddRepoOptions will add a repository with the given options. Backends
+	// should warn about, rather than fail on, unsupported options.
+	AddRepoOptions(name, uri string, opts RepoOptions) error
+
+	// RemovePackages will remove the packages from the root
+	RemovePackages(ignoreSafety bool, packages []string) error
+
+	// ExcludePackages will ensure the packages are never installed by any
+	// following operation. Exclusions are always applied before the first
+	// install, so they cover every operation.
+	ExcludePackages(packages []string) error
+
+	// InstallPackageVersions will install the packages, requesting the exact
+	// version where the backend supports it.
+	InstallPackageVersions(ignoreSafety bool, packages []PackageVersion) error
+
+	// GetInstalledVersion will return the version-release of the installed
+	// package, or an error if it isn't installed.
+	GetInstalledVersion(name string) (string, error)
+
+	// CompareVersions will compare two versions as the backend orders them,
+	// returning -1, 0 or 1.
+	CompareVersions(a, b string) int
+}
+
+// pinnedNames will return the package names, joining any version with the
+// backend specific separator
+func pinnedNames(packages []PackageVersion, sep string) []string {
+	var ret []string
+	for _, p := range packages {
+		if p.Version == "" {
+			ret = append(ret, p.Name)
+		} else {
+			ret = append(ret, p.Name+sep+p.Version)
+		}
+	}
+	return ret
+}
+
+// NewManager will return the package manager requested by the configuration
+func NewManager(conf *config.ImageConfiguration) (Manager, error) {
+	switch conf.Image.PackageManager {
+	case config.PackageManagerEopkg:
+		return NewEopkgManager()
+	case config.PackageManagerDnf, config.PackageManagerYum:
+		return NewDnfManager(string(conf.Image.PackageManager), &conf.Dnf), nil
+	case config.PackageManagerApt:
+		return NewAptManager(&conf.Apt), nil
+	case config.PackageManagerSwupd:
+		return NewSwupdManager(&conf.Swupd), nil
+	default:
+		return nil, fmt.Errorf("Unknown package manager: %v", conf.Image.PackageManager)
+	}
+}
@@ -0,0 +1,149 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package packager
+
+import (
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"github.com/solus-project/libosdev/commands"
+	"libuspin/config"
+	"libuspin/spec"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// SwupdBundleCore is the minimal bundle installed into every root
+const SwupdBundleCore = "os-core"
+
+// SwupdManager populates a Clear Linux rootfs with swupd. swupd has no
+// concept of individual packages, so @group lines are installed as bundles.
+type SwupdManager struct {
+	conf *config.SectionSwupd
+	root string
+}
+
+// NewSwupdManager will return a new SwupdManager for the given configuration
+func NewSwupdManager(conf *config.SectionSwupd) *SwupdManager {
+	return &SwupdManager{
+		conf: conf,
+	}
+}
+
+// Init will ensure swupd is available on the host
+func (s *SwupdManager) Init() error {
+	if _, err := exec.LookPath("swupd"); err != nil {
+		return errors.New("Cannot find swupd on the host")
+	}
+	return nil
+}
+
+// commonArgs are passed to every swupd invocation so that it only touches
+// the root, and always uses the configured content server
+func (s *SwupdManager) commonArgs() []string {
+	return []string{
+		"--path=" + s.root,
+		"--url=" + s.conf.ContentURL,
+		"--statedir=" + filepath.Join(s.root, "var", "lib", "swupd"),
+	}
+}
+
+// InitRoot will install os-core at the pinned version into the root
+func (s *SwupdManager) InitRoot(root string) error {
+	s.root = root
+	if err := os.MkdirAll(filepath.Join(root, "var", "lib", "swupd"), 00755); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"version": s.conf.Version,
+		"url":     s.conf.ContentURL,
+	}).Info("Installing swupd core bundle")
+
+	args := []string{"verify", "--install", "--manifest=" + s.conf.Version}
+	return commands.ExecStdoutArgs("swupd", append(args, s.commonArgs()...))
+}
+
+// AddRepo is unsupported, the content server is set in the [swupd] section
+func (s *SwupdManager) AddRepo(name, uri string) error {
+	return errors.New("swupd does not support repositories, set swupd.content_url instead")
+}
+
+// AddRepoOptions is unsupported, exactly as AddRepo
+func (s *SwupdManager) AddRepoOptions(name, uri string, opts RepoOptions) error {
+	return s.AddRepo(name, uri)
+}
+
+// InstallGroups will add the bundles to the root. ignoreSafety has no
+// meaning for swupd.
+func (s *SwupdManager) InstallGroups(ignoreSafety bool, groups []string) error {
+	var bundles []string
+	for _, group := range groups {
+		// Already installed by InitRoot
+		if group != SwupdBundleCore {
+			bundles = append(bundles, group)
+		}
+	}
+	if len(bundles) == 0 {
+		return nil
+	}
+	args := append([]string{"bundle-add"}, s.commonArgs()...)
+	return commands.ExecStdoutArgs("swupd", append(args, bundles...))
+}
+
+// InstallPackages is always an error, and should have been caught while
+// parsing the packages file
+func (s *SwupdManager) InstallPackages(ignoreSafety bool, packages []string) error {
+	return errors.New("swupd cannot install individual packages, use @bundle instead")
+}
+
+// RemovePackages is always an error, as with InstallPackages
+func (s *SwupdManager) RemovePackages(ignoreSafety bool, packages []string) error {
+	return errors.New("swupd cannot remove individual packages")
+}
+
+// ExcludePackages is always an error, as with InstallPackages
+func (s *SwupdManager) ExcludePackages(packages []string) error {
+	return errors.New("swupd cannot exclude individual packages")
+}
+
+// InstallPackageVersions is always an error, as with InstallPackages
+func (s *SwupdManager) InstallPackageVersions(ignoreSafety bool, packages []PackageVersion) error {
+	return s.InstallPackages(ignoreSafety, nil)
+}
+
+// GetInstalledVersion is always an error, as swupd only versions the OS
+func (s *SwupdManager) GetInstalledVersion(name string) (string, error) {
+	return "", errors.New("swupd has no per-package versions")
+}
+
+// CompareVersions will compare the versions segment by segment, though swupd
+// never has an installed version to compare
+func (s *SwupdManager) CompareVersions(a, b string) int {
+	return spec.CompareVersions(a, b)
+}
+
+// FinalizeRoot has nothing to do, as the swupd state directory must be
+// retained within the root for later updates.
+func (s *SwupdManager) FinalizeRoot() error {
+	return nil
+}
+
+// Cleanup has nothing to do, as swupd runs entirely from the host
+func (s *SwupdManager) Cleanup() error {
+	return nil
+}
@@ -0,0 +1,138 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package spec
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// conditionRegex matches key=value and key!=value, where value may be a
+	// comma separated list of alternatives
+	conditionRegex = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_.-]*)\s*(!?=)\s*(.+)$`)
+)
+
+// A conditionFrame tracks a single [if] block while parsing
+type conditionFrame struct {
+	lineno   int  // Line the block was opened on
+	column   int  // Column of the [if] on that line
+	parent   bool // Whether the enclosing block is active
+	result   bool // Result of the condition itself
+	inElse   bool // Whether we've passed the [else]
+	isActive bool // Whether lines in the current branch are used
+}
+
+// conditionStack tracks the nested [if] blocks of a single file
+type conditionStack struct {
+	frames []*conditionFrame
+}
+
+// active determines whether lines at the current position are used
+func (c *conditionStack) active() bool {
+	if len(c.frames) == 0 {
+		return true
+	}
+	return c.frames[len(c.frames)-1].isActive
+}
+
+// push will open a new [if] block
+func (c *conditionStack) push(lineno, column int, result bool) {
+	parent := c.active()
+	c.frames = append(c.frames, &conditionFrame{
+		lineno:   lineno,
+		column:   column,
+		parent:   parent,
+		result:   result,
+		isActive: parent && result,
+	})
+}
+
+// evalCondition will evaluate a key=value or key!=value condition against the
+// variables. Unset variables are treated as empty.
+func evalCondition(cond string, vars map[string]string) (bool, error) {
+	m := conditionRegex.FindStringSubmatch(strings.TrimSpace(cond))
+	if m == nil {
+		return false, fmt.Errorf("Invalid condition '%v'", cond)
+	}
+	value := vars[m[1]]
+	match := false
+	for _, alt := range strings.Split(m[3], ",") {
+		if strings.TrimSpace(alt) == value {
+			match = true
+			break
+		}
+	}
+	if m[2] == "!=" {
+		return !match, nil
+	}
+	return match, nil
+}
+
+// isConditionDirective determines whether the line is an [if], [else] or
+// [endif] directive
+func isConditionDirective(line string) bool {
+	return strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]")
+}
+
+// handleDirective will update the stack for the [if], [else] or [endif]
+// directive found at the given line and column
+func (c *conditionStack) handleDirective(line string, lineno, column int, vars map[string]string) error {
+	directive := strings.TrimSpace(line[1 : len(line)-1])
+	switch {
+	case strings.HasPrefix(directive, "if "):
+		result, err := evalCondition(directive[3:], vars)
+		// Still open the block, so the matching [endif] isn't reported too
+		c.push(lineno, column, result)
+		if err != nil {
+			return err
+		}
+	case directive == "else":
+		if len(c.frames) == 0 {
+			return fmt.Errorf("[else] without [if]")
+		}
+		frame := c.frames[len(c.frames)-1]
+		if frame.inElse {
+			return fmt.Errorf("Duplicate [else] for [if] on line %v", frame.lineno)
+		}
+		frame.inElse = true
+		frame.isActive = frame.parent && !frame.result
+	case directive == "endif":
+		if len(c.frames) == 0 {
+			return fmt.Errorf("[endif] without [if]")
+		}
+		c.frames = c.frames[:len(c.frames)-1]
+	default:
+		return fmt.Errorf("Unknown directive '%v'", line)
+	}
+	return nil
+}
+
+// splitLineCondition will split a trailing "; key=value" condition from the
+// line, returning the line without it and whether the condition holds.
+func splitLineCondition(line string, vars map[string]string) (string, bool, error) {
+	idx := strings.LastIndex(line, ";")
+	if idx < 0 || !conditionRegex.MatchString(strings.TrimSpace(line[idx+1:])) {
+		return line, true, nil
+	}
+	result, err := evalCondition(line[idx+1:], vars)
+	if err != nil {
+		return "", false, err
+	}
+	return strings.TrimSpace(line[:idx]), result, nil
+}
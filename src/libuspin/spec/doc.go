@@ -29,6 +29,9 @@
 // repository, and the right side is assumed to be the URI of this repository.
 //      RepoName = http://example.com/eopkg-index.xml.xz
 //
+// Repo lines are deprecated in favour of [[repo]] entries in the .spin file,
+// and are rejected by parsers with RejectRepos set.
+//
 // Group lines
 //
 // A line beginning with the group character '@' is interpreted as a request
@@ -42,13 +45,80 @@
 // Any non blank line neither qualifying as a repo or group line is interpreted
 // as a package installation.
 //
+// A package line may also constrain the installed version with one of the
+// ==, !=, >=, <=, > or < operators. The build fails if the installed version
+// does not satisfy the constraint, and if the constraint has no release then
+// the release of the installed package is ignored. Versions are ordered as
+// the package manager orders them, so apt respects epochs and '~'.
+//
+// Only an == version is requested from the package manager, and only where the
+// backend supports it. The other operators are checks alone: the package is
+// installed as usual and the build fails if the resulting version does not
+// satisfy the constraint.
+//      nano == 2.7.1-55
+//      kernel >= 4.8
+//
+// Removal lines
+//
+// A line beginning with the removal character '-' is interpreted as a request
+// to remove the named package, i.e. documentation pulled in by a component.
+//      -kernel-docs
+//
+// Exclusion lines
+//
+// A line beginning with the exclusion character '!' is interpreted as a request
+// to never install the named package. Exclusions apply to the whole file, no
+// matter where they appear, and take effect after the leading repositories but
+// before anything is installed. eopkg cannot prevent a package from being
+// pulled in as a dependency, so exclusions are rejected for eopkg images.
+//      !gcc
+//
+// Groups may not be removed or excluded.
+//
+// Include lines
+//
+// A line beginning with the include directive '%include' will parse the named
+// file, relative to the current file, in place. The path may be a glob, in
+// which case every matching file is included in lexical order, allowing a
+// directory of fragments to be shared between spins.
+//      %include fragments/base.packages
+//      %include drivers/*.packages
+// Including a file that is already being parsed is an error.
+//
+// Conditionals
+//
+// Lines between an [if key=value] and [endif] are only used when the variable
+// matches, with [if key!=value] negating the test. An optional [else] inverts
+// the block, blocks may be nested, and the value may be a comma separated list
+// of alternatives. Unset variables are treated as empty.
+//      [if edition=lite]
+//      budgie-desktop
+//      [else]
+//      @desktop.budgie
+//      [endif]
+// A single line may instead carry a trailing condition after a ';'
+//      wine ; arch=x86_64,i686
+//
+// Variables
+//
+// Any ${name} within a used line is replaced by the value of the variable
+// before the line is parsed, and referencing an unset variable is an error.
+//      kernel-${flavour}
+//
 // Control Characters
 //
 // An additional character, '~', may be used by implementations to control the
-// 'IgnoreSafety' parameter of Package & Group install lines. Depending on the
-// implementation, this will bypass dependency safety checks in order to break
-// a cyclical dependency to inject a group or package before other dependencies
-// are met, such as for baselayout style packages.
+// 'IgnoreSafety' parameter of Package & Group install lines, and of removal
+// lines. Depending on the implementation, this will bypass dependency safety
+// checks in order to break a cyclical dependency to inject a group or package
+// before other dependencies are met, such as for baselayout style packages.
 //
 // This control character must be the first character in the sequence.
+//
+// Errors
+//
+// Names must match the parser's NameRegex once the control characters are
+// stripped, so a lone '@', a repeated or out of order control character, or an
+// unknown one such as '$' is an error. Every invalid line is collected and
+// returned as ParseErrors, each reported in the style "file:line:col: message".
 package spec
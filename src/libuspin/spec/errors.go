@@ -0,0 +1,81 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package spec
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrorCode identifies the class of a ParseError, so that tooling can match
+// on it rather than on the message.
+type ErrorCode string
+
+const (
+	// ErrorCodeCondition is a malformed or unbalanced conditional
+	ErrorCodeCondition ErrorCode = "condition"
+
+	// ErrorCodeInclude is an include that is missing, unreadable or cyclic
+	ErrorCodeInclude ErrorCode = "include"
+
+	// ErrorCodeRepo is a malformed or rejected repo declaration
+	ErrorCodeRepo ErrorCode = "repo"
+
+	// ErrorCodeVariable is a reference to an undefined ${variable}
+	ErrorCodeVariable ErrorCode = "variable"
+
+	// ErrorCodeControlCharacter is an unknown, repeated or misplaced control character
+	ErrorCodeControlCharacter ErrorCode = "control-character"
+
+	// ErrorCodeEmptyName is a line with control characters and no name, i.e. '@'
+	ErrorCodeEmptyName ErrorCode = "empty-name"
+
+	// ErrorCodeInvalidName is a name not matching the parser's NameRegex
+	ErrorCodeInvalidName ErrorCode = "invalid-name"
+
+	// ErrorCodeUnsupported is a valid line the operation does not allow, i.e.
+	// removing a group.
+	ErrorCodeUnsupported ErrorCode = "unsupported"
+)
+
+// A ParseError describes a single problem in a packages file. Line and Column
+// are counted from 1.
+type ParseError struct {
+	File    string
+	Line    int
+	Column  int
+	Code    ErrorCode
+	Message string
+}
+
+// Error will return the error in the compiler style "file:line:col: message"
+func (p *ParseError) Error() string {
+	return fmt.Sprintf("%v:%v:%v: %v [%v]", p.File, p.Line, p.Column, p.Message, p.Code)
+}
+
+// ParseErrors is returned by Parse when one or more lines are invalid, in
+// the order they were found.
+type ParseErrors []*ParseError
+
+// Error will return each error on its own line
+func (p ParseErrors) Error() string {
+	var lines []string
+	for _, e := range p {
+		lines = append(lines, e.Error())
+	}
+	return strings.Join(lines, "\n")
+}
@@ -19,8 +19,20 @@ package spec
 import (
 	"bufio"
 	"fmt"
+	log "github.com/Sirupsen/logrus"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	// DefaultNameRegex is the NameRegex used by NewParser, permitting the
+	// package and group names of the supported package managers, including
+	// dpkg style architecture qualifiers such as "libc6:i386".
+	DefaultNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.+:-]*$`)
 )
 
 // Parser does the heavy lifting of parsing a .spin file to pull all
@@ -30,10 +42,38 @@ type Parser struct {
 	RepoSplitCharacter string // Character to denote a repo definition. Defaults to '='
 	SafetyCharacter    string // Character to indicate ignoreSafety. Defaults to '~'
 	GroupCharacter     string // Character to indicate a group or component. Defaults to '@'
+	RemoveCharacter    string // Character to indicate a package removal. Defaults to '-'
+	ExcludeCharacter   string // Character to indicate a package is never installed. Defaults to '!'
+
+	// RejectPackages is set for package managers that can only install groups,
+	// i.e. swupd bundles, so that package lines are a parse error.
+	RejectPackages bool
+
+	// RejectExcludes is set for package managers that cannot prevent a package
+	// from being installed, i.e. eopkg, so that exclusion lines are a parse error.
+	RejectExcludes bool
+
+	// RejectRepos turns the deprecation warning for repo lines into an error,
+	// as repos should now be defined in the .spin file.
+	RejectRepos bool
+
+	IncludeDirective string // Directive to include other files. Defaults to '%include'
+
+	// NameRegex must match every package, group and repo name once the control
+	// characters have been stripped. Defaults to DefaultNameRegex.
+	NameRegex *regexp.Regexp
+
+	// Vars are used to evaluate [if key=value] blocks and "; key=value" line
+	// suffixes, where unset variables are treated as empty, and to expand
+	// ${name} references, where unset variables are an error.
+	Vars map[string]string
 
 	Stack *OpStack // The parsed stack so far
+	Files []string // Absolute paths of every file parsed, including includes
 
-	curSet *OpSet
+	curSet    *OpSet
+	including []string    // Absolute paths of the files being parsed, for cycle detection
+	errors    ParseErrors // Problems found so far
 }
 
 // NewParser will return a new parser for the image specification file
@@ -43,6 +83,10 @@ func NewParser() *Parser {
 		RepoSplitCharacter: "=",
 		SafetyCharacter:    "~",
 		GroupCharacter:     "@",
+		RemoveCharacter:    "-",
+		ExcludeCharacter:   "!",
+		IncludeDirective:   "%include",
+		NameRegex:          DefaultNameRegex,
 		Stack:              &OpStack{},
 	}
 }
@@ -66,60 +110,262 @@ insertOp:
 	i.curSet.Ops = append(i.curSet.Ops, op)
 }
 
-// Parse will attempt to parse the given image speicifcation file at the given
-// path, and will return an error if this fails.
+// Parse will attempt to parse the given image specification file at the given
+// path. Every invalid line is collected and returned as ParseErrors, whereas
+// failing to read the file itself returns the underlying error.
 func (i *Parser) Parse(path string) error {
+	i.errors = nil
+	if err := i.parseFile(path); err != nil {
+		return err
+	}
+
+	if len(i.errors) > 0 {
+		errs := i.errors
+		i.errors = nil
+		i.curSet = nil
+		return errs
+	}
+
+	i.Stack.Blocks = append(i.Stack.Blocks, i.curSet)
+	i.curSet = nil
+	i.Stack.hoistExclusions()
+
+	return nil
+}
+
+// errorf will record a ParseError for the given position and carry on
+func (i *Parser) errorf(path string, lineno, column int, code ErrorCode, format string, args ...interface{}) {
+	i.errors = append(i.errors, &ParseError{
+		File:    path,
+		Line:    lineno,
+		Column:  column,
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// include will parse every file matching the pattern, relative to the file
+// currently being parsed, into the same stack. Problems are reported against
+// the include line itself.
+func (i *Parser) include(path string, lineno, column int, pattern string) {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(filepath.Dir(path), pattern)
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		i.errorf(path, lineno, column, ErrorCodeInclude, "Invalid include pattern '%v': %v", pattern, err)
+		return
+	}
+	if len(matches) == 0 {
+		i.errorf(path, lineno, column, ErrorCodeInclude, "No files match include '%v'", pattern)
+		return
+	}
+	for _, match := range matches {
+		abspath, err := filepath.Abs(match)
+		if err != nil {
+			i.errorf(path, lineno, column, ErrorCodeInclude, "Cannot include '%v': %v", match, err)
+			continue
+		}
+		cycle := false
+		for n, p := range i.including {
+			if p == abspath {
+				chain := append(i.including[n:len(i.including):len(i.including)], abspath)
+				i.errorf(path, lineno, column, ErrorCodeInclude, "Include cycle detected: %v", strings.Join(chain, " -> "))
+				cycle = true
+				break
+			}
+		}
+		if cycle {
+			continue
+		}
+		if err := i.parseFile(match); err != nil {
+			i.errorf(path, lineno, column, ErrorCodeInclude, "Cannot include '%v': %v", match, err)
+		}
+	}
+}
+
+// checkName will record an error and return false if the name left after
+// stripping the control characters is empty, still begins with a control
+// character, or does not match the NameRegex.
+func (i *Parser) checkName(path string, lineno, column int, line, name string) bool {
+	if name == "" {
+		i.errorf(path, lineno, column, ErrorCodeEmptyName, "Missing name after control characters in '%v'", line)
+		return false
+	}
+	for _, c := range []string{i.SafetyCharacter, i.GroupCharacter, i.RemoveCharacter, i.ExcludeCharacter} {
+		if strings.HasPrefix(name, c) {
+			i.errorf(path, lineno, column, ErrorCodeControlCharacter, "Control character '%v' is repeated or out of order in '%v'", c, line)
+			return false
+		}
+	}
+	if i.NameRegex.MatchString(name) {
+		return true
+	}
+	if r, _ := utf8.DecodeRuneInString(name); !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+		i.errorf(path, lineno, column, ErrorCodeControlCharacter, "Unknown control character '%c' in '%v'", r, line)
+		return false
+	}
+	i.errorf(path, lineno, column, ErrorCodeInvalidName, "Invalid name '%v'", name)
+	return false
+}
+
+// parseFile will parse the file at path into the stack, following includes.
+// Only a failure to read the file is returned, all other problems are
+// collected by errorf.
+func (i *Parser) parseFile(path string) error {
+	abspath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	i.including = append(i.including, abspath)
+	defer func() {
+		i.including = i.including[:len(i.including)-1]
+	}()
+
 	fi, err := os.Open(path)
 	if err != nil {
 		return err
 	}
 	defer fi.Close()
+	i.Files = append(i.Files, abspath)
 	sc := bufio.NewScanner(fi)
 
 	lineno := 0
+	conds := &conditionStack{}
 
 	for sc.Scan() {
-		line := strings.TrimSpace(sc.Text())
+		raw := sc.Text()
+		line := strings.TrimSpace(raw)
 		lineno++
 
 		// ~ character ignores safety.
 		ignoreSafety := false
 		isGroup := false
+		isRemove := false
+		isExclude := false
 
 		if line == "" {
 			continue
 		}
 
+		// Columns count from 1, after any indentation
+		column := strings.Index(raw, line) + 1
+
 		// Check for single line comments
 		if strings.HasPrefix(line, i.CommentCharacter) {
 			continue
 		}
 
+		// Conditional blocks must always be tracked to handle nesting
+		if isConditionDirective(line) {
+			if err := conds.handleDirective(line, lineno, column, i.Vars); err != nil {
+				i.errorf(path, lineno, column, ErrorCodeCondition, "%v", err)
+			}
+			continue
+		}
+		if !conds.active() {
+			continue
+		}
+
+		// Expand ${name} references to the variables
+		line, err := ExpandVars(line, i.Vars)
+		if err != nil {
+			i.errorf(path, lineno, column, ErrorCodeVariable, "%v", err)
+			continue
+		}
+
+		// Check for a per-line condition
+		line, use, err := splitLineCondition(line, i.Vars)
+		if err != nil {
+			i.errorf(path, lineno, column, ErrorCodeCondition, "%v", err)
+			continue
+		}
+		if !use {
+			continue
+		}
+
+		// Parse included files in place
+		if strings.HasPrefix(line, i.IncludeDirective) {
+			pattern := strings.TrimSpace(line[len(i.IncludeDirective):])
+			if pattern == "" {
+				i.errorf(path, lineno, column, ErrorCodeInclude, "Missing path for include")
+				continue
+			}
+			i.include(path, lineno, column, pattern)
+			continue
+		}
+
+		// Check for a version constraint, which would otherwise look like a repo
+		line, constraint := splitConstraint(line)
+
 		// Check if this is a repo
-		if strings.Contains(line, i.RepoSplitCharacter) {
-			fields := strings.Split(line, "=")
-			value := strings.TrimSpace(strings.Join(fields[1:], "="))
+		if constraint == nil && strings.Contains(line, i.RepoSplitCharacter) {
+			fields := strings.Split(line, i.RepoSplitCharacter)
+			name := strings.TrimSpace(fields[0])
+			value := strings.TrimSpace(strings.Join(fields[1:], i.RepoSplitCharacter))
 			if value == "" {
-				return fmt.Errorf("Missing value for repo declaration '%v' on line '%v'\n", fields[0], lineno)
+				i.errorf(path, lineno, column, ErrorCodeRepo, "Missing value for repo declaration '%v'", name)
+				continue
+			}
+			if !i.checkName(path, lineno, column, line, name) {
+				continue
 			}
+			if i.RejectRepos {
+				i.errorf(path, lineno, column, ErrorCodeRepo, "Repo declaration '%v' must be moved to a [[repo]] in the .spin file", name)
+				continue
+			}
+			log.WithFields(log.Fields{
+				"file": path,
+				"line": lineno,
+			}).Warning("Repo declarations in packages files are deprecated, use [[repo]] in the .spin file")
 			op := &OpRepo{
-				RepoName: strings.TrimSpace(fields[0]),
+				RepoName: name,
 				RepoURI:  value,
 			}
 			i.pushOperation(op)
 			continue
 		}
 
+		name := line
+
 		// Check if safety is disabled
-		if strings.HasPrefix(line, i.SafetyCharacter) {
+		if strings.HasPrefix(name, i.SafetyCharacter) {
 			ignoreSafety = true
-			line = line[len(i.SafetyCharacter):]
+			name = name[len(i.SafetyCharacter):]
 		}
 
 		// Check if its a group or not
-		if strings.HasPrefix(line, i.GroupCharacter) {
+		if strings.HasPrefix(name, i.GroupCharacter) {
 			isGroup = true
-			line = line[len(i.GroupCharacter):]
+			name = name[len(i.GroupCharacter):]
+		}
+
+		// Check if its a removal or exclusion
+		if strings.HasPrefix(name, i.RemoveCharacter) {
+			isRemove = true
+			name = name[len(i.RemoveCharacter):]
+		} else if strings.HasPrefix(name, i.ExcludeCharacter) {
+			isExclude = true
+			name = name[len(i.ExcludeCharacter):]
+		}
+
+		if !i.checkName(path, lineno, column+len(line)-len(name), line, name) {
+			continue
+		}
+
+		if (isRemove || isExclude) && (isGroup || i.RejectPackages) {
+			i.errorf(path, lineno, column, ErrorCodeUnsupported, "Cannot remove or exclude '%v'", name)
+			continue
+		}
+
+		if isExclude && i.RejectExcludes {
+			i.errorf(path, lineno, column, ErrorCodeUnsupported, "Exclusions are not supported by this package manager: '%v'", name)
+			continue
+		}
+
+		if constraint != nil && (isGroup || isRemove || isExclude) {
+			i.errorf(path, lineno, column, ErrorCodeUnsupported, "Only package installs may have a version constraint: '%v'", name)
+			continue
 		}
 
 		var op Operation
@@ -127,20 +373,34 @@ func (i *Parser) Parse(path string) error {
 		// Add the operation to the stack
 		if isGroup {
 			op = &OpGroup{
-				GroupName:    line,
+				GroupName:    name,
+				IgnoreSafety: ignoreSafety,
+			}
+		} else if isRemove {
+			op = &OpRemove{
+				Name:         name,
 				IgnoreSafety: ignoreSafety,
 			}
+		} else if isExclude {
+			op = &OpExclude{
+				Name: name,
+			}
 		} else {
+			if i.RejectPackages {
+				i.errorf(path, lineno, column, ErrorCodeUnsupported, "Individual packages are not supported, use a %vgroup instead: '%v'", i.GroupCharacter, name)
+				continue
+			}
 			op = &OpPackage{
-				Name:         line,
+				Name:         name,
 				IgnoreSafety: ignoreSafety,
+				Constraint:   constraint,
 			}
 		}
 		i.pushOperation(op)
 	}
 
-	i.Stack.Blocks = append(i.Stack.Blocks, i.curSet)
-	i.curSet = nil
-
-	return nil
+	for _, frame := range conds.frames {
+		i.errorf(path, frame.lineno, frame.column, ErrorCodeCondition, "Missing [endif] for [if]")
+	}
+	return sc.Err()
 }
@@ -17,11 +17,20 @@
 package spec
 
 import (
+	"regexp"
+	"strings"
 	"testing"
 )
 
 const (
 	minimalFile = "../../../testdata/minimal.packages"
+	removeFile  = "../../../testdata/remove.packages"
+	excludeFile = "../../../testdata/exclude.packages"
+	includeFile = "../../../testdata/include.packages"
+	cycleFile   = "../../../testdata/fragments/cycle-a.packages"
+	condFile    = "../../../testdata/conditional.packages"
+	errorsFile  = "../../../testdata/errors.packages"
+	varsFile    = "../../../testdata/vars.packages"
 )
 
 func TestParseMinimalImage(t *testing.T) {
@@ -35,3 +44,218 @@ func TestParseMinimalImage(t *testing.T) {
 		t.Fatalf("Incorrect number of blocks for config: %v\n", len(p.Stack.Blocks))
 	}
 }
+
+func TestParseRejectPackages(t *testing.T) {
+	p := NewParser()
+	p.RejectPackages = true
+
+	if err := p.Parse(minimalFile); err == nil {
+		t.Fatalf("Package lines should be rejected\n")
+	}
+}
+
+func TestParseRemoveExclude(t *testing.T) {
+	p := NewParser()
+
+	if err := p.Parse(removeFile); err != nil {
+		t.Fatalf("Failed to parse remove file: %v\n", err)
+	}
+	if len(p.Stack.Blocks) != 6 {
+		t.Fatalf("Incorrect number of blocks for config: %v\n", len(p.Stack.Blocks))
+	}
+	// Exclusions are stacked ahead of every install
+	if len(p.Stack.Blocks[0].Ops) != 2 {
+		t.Fatalf("Exclusions should be stacked\n")
+	}
+	if op, ok := p.Stack.Blocks[0].Ops[1].(*OpExclude); !ok || op.Name != "binutils" {
+		t.Fatalf("Invalid exclude operation: %v\n", p.Stack.Blocks[0].Ops[1])
+	}
+	if op, ok := p.Stack.Blocks[4].Ops[0].(*OpRemove); !ok || op.Name != "kernel-docs" || op.IgnoreSafety {
+		t.Fatalf("Invalid remove operation: %v\n", p.Stack.Blocks[4].Ops[0])
+	}
+	if op, ok := p.Stack.Blocks[5].Ops[0].(*OpRemove); !ok || !op.IgnoreSafety {
+		t.Fatalf("Invalid remove operation: %v\n", p.Stack.Blocks[5].Ops[0])
+	}
+}
+
+func TestParseRejectExcludes(t *testing.T) {
+	p := NewParser()
+	p.RejectExcludes = true
+
+	err := p.Parse(removeFile)
+	errs, ok := err.(ParseErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("Exclusion lines should be rejected: %v\n", err)
+	}
+	for _, e := range errs {
+		if e.Code != ErrorCodeUnsupported {
+			t.Fatalf("Wrong error code for exclusion: %v\n", e)
+		}
+	}
+}
+
+func TestParseExcludeHoisted(t *testing.T) {
+	p := NewParser()
+
+	if err := p.Parse(excludeFile); err != nil {
+		t.Fatalf("Failed to parse exclude file: %v\n", err)
+	}
+	if len(p.Stack.Blocks) != 3 {
+		t.Fatalf("Incorrect number of blocks for config: %v\n", len(p.Stack.Blocks))
+	}
+	if _, ok := p.Stack.Blocks[0].Ops[0].(*OpRepo); !ok {
+		t.Fatalf("Repositories should come first: %v\n", p.Stack.Blocks[0].Ops[0])
+	}
+	if len(p.Stack.Blocks[1].Ops) != 2 {
+		t.Fatalf("Exclusions should be applied before any install\n")
+	}
+	if len(p.Stack.Blocks[2].Ops) != 2 {
+		t.Fatalf("Packages split by an exclusion should be rejoined\n")
+	}
+}
+
+func TestParseInclude(t *testing.T) {
+	p := NewParser()
+
+	if err := p.Parse(includeFile); err != nil {
+		t.Fatalf("Failed to parse include file: %v\n", err)
+	}
+	if len(p.Stack.Blocks) != 3 {
+		t.Fatalf("Incorrect number of blocks for config: %v\n", len(p.Stack.Blocks))
+	}
+	var names []string
+	for _, op := range p.Stack.Blocks[2].Ops {
+		names = append(names, op.(*OpPackage).Name)
+	}
+	if strings.Join(names, " ") != "budgie-desktop mate-desktop nano" {
+		t.Fatalf("Included packages in wrong order: %v\n", names)
+	}
+	if len(p.Files) != 4 {
+		t.Fatalf("Invalid number of parsed files: %v\n", p.Files)
+	}
+
+	p = NewParser()
+	err := p.Parse(cycleFile)
+	if err == nil {
+		t.Fatalf("Include cycle should not parse\n")
+	}
+	if !strings.Contains(err.Error(), "cycle") {
+		t.Fatalf("Wrong error for include cycle: %v\n", err)
+	}
+}
+
+// stackNames flattens the names of all install operations in the stack
+func stackNames(s *OpStack) string {
+	var names []string
+	for _, block := range s.Blocks {
+		for _, op := range block.Ops {
+			switch o := op.(type) {
+			case *OpPackage:
+				names = append(names, o.Name)
+			case *OpGroup:
+				names = append(names, "@"+o.GroupName)
+			}
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+func TestParseConditional(t *testing.T) {
+	tests := []struct {
+		vars     map[string]string
+		expected string
+	}{
+		{
+			map[string]string{"edition": "lite", "arch": "x86_64"},
+			"baselayout @system.base budgie-desktop wine nano",
+		},
+		{
+			map[string]string{"arch": "x86_64"},
+			"baselayout @system.base @desktop.budgie steam libreoffice wine",
+		},
+		{
+			map[string]string{"arch": "aarch64"},
+			"baselayout @system.base @desktop.budgie libreoffice",
+		},
+	}
+	for _, test := range tests {
+		p := NewParser()
+		p.Vars = test.vars
+		if err := p.Parse(condFile); err != nil {
+			t.Fatalf("Failed to parse conditional file: %v\n", err)
+		}
+		if names := stackNames(p.Stack); names != test.expected {
+			t.Fatalf("Wrong packages for %v: %v\n", test.vars, names)
+		}
+	}
+}
+
+func TestConditionErrors(t *testing.T) {
+	vars := map[string]string{}
+	for _, line := range []string{"[else]", "[endif]", "[if arch]", "[unless arch=x86_64]"} {
+		c := &conditionStack{}
+		if err := c.handleDirective(line, 1, 1, vars); err == nil {
+			t.Fatalf("Invalid directive should not parse: %v\n", line)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	expected := []ParseError{
+		{Line: 2, Column: 2, Code: ErrorCodeEmptyName},
+		{Line: 3, Column: 2, Code: ErrorCodeControlCharacter},
+		{Line: 4, Column: 2, Code: ErrorCodeControlCharacter},
+		{Line: 5, Column: 1, Code: ErrorCodeControlCharacter},
+		{Line: 6, Column: 1, Code: ErrorCodeInvalidName},
+		{Line: 7, Column: 3, Code: ErrorCodeCondition},
+		{Line: 8, Column: 1, Code: ErrorCodeRepo},
+		{Line: 9, Column: 1, Code: ErrorCodeUnsupported},
+		{Line: 10, Column: 1, Code: ErrorCodeCondition},
+	}
+	p := NewParser()
+	err := p.Parse(errorsFile)
+	errs, ok := err.(ParseErrors)
+	if !ok {
+		t.Fatalf("Expected ParseErrors, got: %v\n", err)
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %v errors, got %v:\n%v\n", len(expected), len(errs), errs)
+	}
+	for n, e := range errs {
+		want := expected[n]
+		if e.File != errorsFile || e.Line != want.Line || e.Column != want.Column || e.Code != want.Code {
+			t.Fatalf("Wrong error, expected %v:%v [%v]: %v\n", want.Line, want.Column, want.Code, e)
+		}
+	}
+	if !strings.HasPrefix(errs[0].Error(), errorsFile+":2:2: ") {
+		t.Fatalf("Error is not in compiler style: %v\n", errs[0])
+	}
+}
+
+func TestParseNameRegex(t *testing.T) {
+	p := NewParser()
+	p.NameRegex = regexp.MustCompile(`^[a-z]+$`)
+	err := p.Parse(minimalFile)
+	errs, ok := err.(ParseErrors)
+	if !ok || len(errs) == 0 || errs[0].Code != ErrorCodeInvalidName {
+		t.Fatalf("Names should be checked against NameRegex: %v\n", err)
+	}
+}
+
+func TestParseVars(t *testing.T) {
+	p := NewParser()
+	p.Vars = map[string]string{"flavour": "lts"}
+	if err := p.Parse(varsFile); err != nil {
+		t.Fatalf("Failed to parse vars file: %v\n", err)
+	}
+	if names := stackNames(p.Stack); names != "baselayout @system.base kernel-lts kernel-lts-modules" {
+		t.Fatalf("Variables not expanded: %v\n", names)
+	}
+
+	p = NewParser()
+	err := p.Parse(varsFile)
+	errs, ok := err.(ParseErrors)
+	if !ok || len(errs) != 2 || errs[0].Code != ErrorCodeVariable {
+		t.Fatalf("Undefined variables should be errors: %v\n", err)
+	}
+}
@@ -25,6 +25,40 @@ type OpStack struct {
 	Blocks []*OpSet // A set of type-similar OpSet's
 }
 
+// hoistExclusions will move every exclusion into a single set ahead of all
+// but the leading repositories, so that an excluded package is never installed
+// regardless of where it was excluded in the file.
+func (s *OpStack) hoistExclusions() {
+	excludes := &OpSet{}
+	var blocks []*OpSet
+	for _, set := range s.Blocks {
+		if set == nil || len(set.Ops) == 0 {
+			continue
+		}
+		if _, ok := set.Ops[0].(*OpExclude); ok {
+			excludes.Ops = append(excludes.Ops, set.Ops...)
+			continue
+		}
+		// Rejoin the sets that an exclusion had split apart
+		if n := len(blocks); n > 0 && set.Ops[0].Compatible(blocks[n-1].Ops[0]) {
+			blocks[n-1].Ops = append(blocks[n-1].Ops, set.Ops...)
+			continue
+		}
+		blocks = append(blocks, set)
+	}
+	if len(excludes.Ops) == 0 {
+		s.Blocks = blocks
+		return
+	}
+	at := 0
+	for ; at < len(blocks); at++ {
+		if _, ok := blocks[at].Ops[0].(*OpRepo); !ok {
+			break
+		}
+	}
+	s.Blocks = append(blocks[:at:at], append([]*OpSet{excludes}, blocks[at:]...)...)
+}
+
 // OpSet has a given type of operations that it supports
 type OpSet struct {
 	Ops []Operation // Slice of operations of the same type
@@ -40,6 +74,8 @@ type OpRepo struct {
 	Operation
 	RepoName string // Name to give the repository
 	RepoURI  string // URI of the repository in question
+	Priority int    // Backend specific priority, only set from the .spin file
+	Key      string // Signing key path or URL, only set from the .spin file
 }
 
 // Compatible will always return false as OpRepo cannot be stacked
@@ -68,8 +104,9 @@ func (o *OpGroup) Compatible(o2 Operation) bool {
 // An OpPackage is an operation to install a given package
 type OpPackage struct {
 	Operation
-	Name         string // Name of the package to install
-	IgnoreSafety bool   // Whether to bypass dependency safety checks
+	Name         string             // Name of the package to install
+	IgnoreSafety bool               // Whether to bypass dependency safety checks
+	Constraint   *VersionConstraint // Optional constraint on the installed version
 }
 
 // Compatible determines if two OpPackage's are compatible with one another
@@ -82,3 +119,33 @@ func (o *OpPackage) Compatible(o2 Operation) bool {
 	}
 	return true
 }
+
+// An OpRemove is an operation to remove a given package, i.e. documentation
+// pulled in by a component
+type OpRemove struct {
+	Operation
+	Name         string // Name of the package to remove
+	IgnoreSafety bool   // Whether to bypass dependency safety checks
+}
+
+// Compatible determines if two OpRemove's are compatible with one another
+func (o *OpRemove) Compatible(o2 Operation) bool {
+	if reflect.TypeOf(o) != reflect.TypeOf(o2) {
+		return false
+	}
+	if o2.(*OpRemove).IgnoreSafety != o.IgnoreSafety {
+		return false
+	}
+	return true
+}
+
+// An OpExclude is an operation to ensure a given package is never installed
+type OpExclude struct {
+	Operation
+	Name string // Name of the package to exclude
+}
+
+// Compatible determines if two OpExclude's are compatible with one another
+func (o *OpExclude) Compatible(o2 Operation) bool {
+	return reflect.TypeOf(o) == reflect.TypeOf(o2)
+}
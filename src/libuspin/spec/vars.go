@@ -0,0 +1,45 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package spec
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var (
+	// varRegex matches a ${name} reference to a variable
+	varRegex = regexp.MustCompile(`\$\{([^}]*)\}`)
+)
+
+// ExpandVars will replace every ${name} in s with the value of the named
+// variable, returning an error for the first variable that is not set.
+func ExpandVars(s string, vars map[string]string) (string, error) {
+	var err error
+	ret := varRegex.ReplaceAllStringFunc(s, func(ref string) string {
+		name := ref[2 : len(ref)-1]
+		value, ok := vars[name]
+		if !ok && err == nil {
+			err = fmt.Errorf("Undefined variable '%v'", name)
+		}
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+	return ret, nil
+}
@@ -0,0 +1,255 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package spec
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// constraintRegex matches "name op version", i.e. "nano == 2.7.1-55"
+	constraintRegex = regexp.MustCompile(`^(\S+?)\s*(==|!=|>=|<=|>|<)\s*(\S+)$`)
+
+	// versionSegmentRegex splits a version into numeric and alphabetic parts
+	versionSegmentRegex = regexp.MustCompile(`[0-9]+|[A-Za-z]+`)
+)
+
+// A VersionConstraint restricts the installed version of a package
+type VersionConstraint struct {
+	Op      string // One of ==, !=, >=, <=, > or <
+	Version string // Version to compare against, with an optional -release
+}
+
+func (v *VersionConstraint) String() string {
+	return v.Op + " " + v.Version
+}
+
+// splitConstraint will split a "name op version" line, returning a nil
+// constraint if the line has none.
+func splitConstraint(line string) (string, *VersionConstraint) {
+	m := constraintRegex.FindStringSubmatch(line)
+	if m == nil {
+		return line, nil
+	}
+	return m[1], &VersionConstraint{Op: m[2], Version: m[3]}
+}
+
+// A VersionCompareFunc compares two versions, returning -1, 0 or 1. Each
+// package manager orders versions in its own way.
+type VersionCompareFunc func(a, b string) int
+
+// CompareVersions compares two version-release strings in the style of
+// rpmvercmp, returning -1, 0 or 1. The versions before the last '-' are
+// compared first, and the releases only if the versions are equal, so that
+// "4.8-300" is older than "4.8.1-2".
+func CompareVersions(a, b string) int {
+	va, ra := splitRelease(a)
+	vb, rb := splitRelease(b)
+	if c := compareSegments(va, vb); c != 0 {
+		return c
+	}
+	return compareSegments(ra, rb)
+}
+
+// splitRelease will split a version-release string at the last '-'
+func splitRelease(v string) (string, string) {
+	if idx := strings.LastIndex(v, "-"); idx >= 0 {
+		return v[:idx], v[idx+1:]
+	}
+	return v, ""
+}
+
+// compareSegments compares two versions segment by segment, returning -1, 0
+// or 1. Numeric segments are compared numerically, and are always newer than
+// alphabetic segments.
+func compareSegments(a, b string) int {
+	sa := versionSegmentRegex.FindAllString(a, -1)
+	sb := versionSegmentRegex.FindAllString(b, -1)
+
+	for i := 0; i < len(sa) && i < len(sb); i++ {
+		na, errA := strconv.ParseUint(sa[i], 10, 64)
+		nb, errB := strconv.ParseUint(sb[i], 10, 64)
+		switch {
+		case errA == nil && errB == nil:
+			if na != nb {
+				if na < nb {
+					return -1
+				}
+				return 1
+			}
+		case errA == nil:
+			return 1
+		case errB == nil:
+			return -1
+		default:
+			if c := strings.Compare(sa[i], sb[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	switch {
+	case len(sa) < len(sb):
+		return -1
+	case len(sa) > len(sb):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// CompareDebianVersions compares two versions in the style of dpkg, returning
+// -1, 0 or 1. The epoch before any ':' is compared first, then the upstream
+// version and finally the revision after the last '-'. Within each part '~'
+// sorts before anything, even the end of the part, so that "2.0~rc1" is older
+// than "2.0".
+func CompareDebianVersions(a, b string) int {
+	ea, ua, ra := splitDebianVersion(a)
+	eb, ub, rb := splitDebianVersion(b)
+	if ea != eb {
+		if ea < eb {
+			return -1
+		}
+		return 1
+	}
+	if c := compareDebianPart(ua, ub); c != 0 {
+		return c
+	}
+	return compareDebianPart(ra, rb)
+}
+
+// splitDebianVersion will split a version into its epoch, upstream version
+// and revision
+func splitDebianVersion(v string) (uint64, string, string) {
+	var epoch uint64
+	if idx := strings.Index(v, ":"); idx >= 0 {
+		epoch, _ = strconv.ParseUint(v[:idx], 10, 64)
+		v = v[idx+1:]
+	}
+	if idx := strings.LastIndex(v, "-"); idx >= 0 {
+		return epoch, v[:idx], v[idx+1:]
+	}
+	return epoch, v, ""
+}
+
+// debianOrder returns the weight of the character at i within a non-digit
+// run, with the end of the string weighing 0
+func debianOrder(s string, i int) int {
+	if i >= len(s) {
+		return 0
+	}
+	c := s[i]
+	switch {
+	case c == '~':
+		return -1
+	case c >= '0' && c <= '9':
+		return 0
+	case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		return int(c)
+	default:
+		return int(c) + 256
+	}
+}
+
+// isDigitAt determines whether the character at i is a digit
+func isDigitAt(s string, i int) bool {
+	return i < len(s) && s[i] >= '0' && s[i] <= '9'
+}
+
+// compareDebianPart compares an upstream version or revision as dpkg's
+// verrevcmp does, alternating between non-digit and digit runs.
+func compareDebianPart(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		for (i < len(a) && !isDigitAt(a, i)) || (j < len(b) && !isDigitAt(b, j)) {
+			ac, bc := debianOrder(a, i), debianOrder(b, j)
+			if ac != bc {
+				if ac < bc {
+					return -1
+				}
+				return 1
+			}
+			i++
+			j++
+		}
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+		diff := 0
+		for isDigitAt(a, i) && isDigitAt(b, j) {
+			if diff == 0 {
+				diff = int(a[i]) - int(b[j])
+			}
+			i++
+			j++
+		}
+		switch {
+		case isDigitAt(a, i):
+			return 1
+		case isDigitAt(b, j):
+			return -1
+		case diff < 0:
+			return -1
+		case diff > 0:
+			return 1
+		}
+	}
+	return 0
+}
+
+// Satisfied determines whether the installed version satisfies the
+// constraint, comparing versions with the given function. If the constraint
+// has no release, the release of the installed version is ignored, so that
+// "kernel >= 4.8" matches "4.8.12-11".
+func (v *VersionConstraint) Satisfied(installed string, compare VersionCompareFunc) bool {
+	if !strings.Contains(v.Version, "-") {
+		if idx := strings.LastIndex(installed, "-"); idx > 0 {
+			installed = installed[:idx]
+		}
+	}
+	c := compare(installed, v.Version)
+	switch v.Op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case ">=":
+		return c >= 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case "<":
+		return c < 0
+	default:
+		return false
+	}
+}
+
+// Verify will return an error if the installed version does not satisfy the
+// constraint for the named package
+func (v *VersionConstraint) Verify(name, installed string, compare VersionCompareFunc) error {
+	if !v.Satisfied(installed, compare) {
+		return fmt.Errorf("Installed %v %v does not satisfy %v", name, installed, v)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+//
+// Copyright © 2016 Ikey Doherty <ikey@solus-project.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package spec
+
+import (
+	"testing"
+)
+
+const (
+	versionsFile = "../../../testdata/versions.packages"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"2.7.1", "2.7.1", 0},
+		{"2.7.10", "2.7.9", 1},
+		{"4.8", "4.8.12", -1},
+		{"1.0a", "1.0b", -1},
+		{"1.0.1", "1.0a", 1},
+		{"2.7.1-55", "2.7.1-9", 1},
+		{"4.8-300", "4.8.1-2", -1},
+		{"1.0-2", "1.0.1-1", -1},
+		{"1.0-10", "1.0-9", 1},
+	}
+	for _, test := range tests {
+		if c := CompareVersions(test.a, test.b); c != test.expected {
+			t.Fatalf("CompareVersions(%v, %v) = %v, expected %v", test.a, test.b, c, test.expected)
+		}
+	}
+}
+
+func TestCompareDebianVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"2.7.1-1", "2.7.1-1", 0},
+		{"1:2.7.1-1", "2.7.1-1", 1},
+		{"1:2.7.1-1", "2:1.0-1", -1},
+		{"2.0~rc1-1", "2.0-1", -1},
+		{"2.0~rc1", "2.0~rc2", -1},
+		{"2.0~~", "2.0~", -1},
+		{"2.0", "2.0a", -1},
+		{"2.0a", "2.0+b1", -1},
+		{"2.10-1", "2.9-1", 1},
+		{"2.7.1", "2.7.1-0", 0},
+		{"1.0-1ubuntu2", "1.0-1ubuntu10", -1},
+	}
+	for _, test := range tests {
+		if c := CompareDebianVersions(test.a, test.b); c != test.expected {
+			t.Fatalf("CompareDebianVersions(%v, %v) = %v, expected %v", test.a, test.b, c, test.expected)
+		}
+		if c := CompareDebianVersions(test.b, test.a); c != -test.expected {
+			t.Fatalf("CompareDebianVersions(%v, %v) = %v, expected %v", test.b, test.a, c, -test.expected)
+		}
+	}
+
+	// Epochs are never mistaken for version segments
+	constraint := &VersionConstraint{"==", "1:2.7.1"}
+	if !constraint.Satisfied("1:2.7.1-1", CompareDebianVersions) {
+		t.Fatalf("1:2.7.1-1 should satisfy %v", constraint)
+	}
+	constraint = &VersionConstraint{">=", "2.0"}
+	if constraint.Satisfied("2.0~rc1-1", CompareDebianVersions) {
+		t.Fatalf("2.0~rc1-1 should not satisfy %v", constraint)
+	}
+}
+
+func TestVersionConstraint(t *testing.T) {
+	tests := []struct {
+		constraint VersionConstraint
+		installed  string
+		expected   bool
+	}{
+		{VersionConstraint{"==", "2.7.1-55"}, "2.7.1-55", true},
+		{VersionConstraint{"==", "2.7.1-55"}, "2.7.1-54", false},
+		{VersionConstraint{"==", "2.7.1"}, "2.7.1-54", true},
+		{VersionConstraint{">=", "4.8"}, "4.8.12-11", true},
+		{VersionConstraint{">=", "4.8"}, "4.4.38-30", false},
+		{VersionConstraint{"<", "4.9"}, "4.8.12-11", true},
+		{VersionConstraint{"!=", "4.9"}, "4.9-1", false},
+		{VersionConstraint{">=", "4.8.1-2"}, "4.8-300", false},
+	}
+	for _, test := range tests {
+		if test.constraint.Satisfied(test.installed, CompareVersions) != test.expected {
+			t.Fatalf("%v %v should be %v", test.installed, &test.constraint, test.expected)
+		}
+	}
+}
+
+func TestParseVersions(t *testing.T) {
+	p := NewParser()
+	if err := p.Parse(versionsFile); err != nil {
+		t.Fatalf("Failed to parse versions file: %v\n", err)
+	}
+	if len(p.Stack.Blocks) != 2 {
+		t.Fatalf("Incorrect number of blocks for config: %v\n", len(p.Stack.Blocks))
+	}
+	nano := p.Stack.Blocks[0].Ops[0].(*OpPackage)
+	if nano.Name != "nano" || nano.Constraint == nil || nano.Constraint.Version != "2.7.1-55" {
+		t.Fatalf("Invalid version constraint: %v %v\n", nano.Name, nano.Constraint)
+	}
+	if p.Stack.Blocks[1].Ops[0].(*OpPackage).Constraint != nil {
+		t.Fatalf("Unversioned package has a constraint\n")
+	}
+}
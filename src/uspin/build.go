@@ -16,8 +16,15 @@
 
 package main
 
-// Build will attempt to build the image, and return an error if this fails
-func (s *USpin) Build() error {
+import (
+	log "github.com/Sirupsen/logrus"
+	"libuspin/build"
+)
+
+// Build will attempt to build the image, and return an error if this fails.
+// The workspace is removed once the build succeeds, and otherwise kept for
+// inspection or to resume the build, skipping the stages it completed.
+func (s *USpin) Build() (err error) {
 	// Initialise our builder before we go anywhere
 	if err := s.builder.Init(s.spec); err != nil {
 		s.logImage.Error(err)
@@ -29,9 +36,52 @@ func (s *USpin) Build() error {
 		return err
 	}
 
+	// Every build gets its own workspace, so builds never collide, unless we
+	// are resuming the last failed build
+	root := s.spec.Config.Image.Workspace
+	if s.resume {
+		if s.workspace, err = build.FindWorkspace(root, s.name, s.spin); err != nil {
+			s.logImage.Error(err)
+			return err
+		}
+		if s.workspace == nil {
+			s.logImage.Warning("No workspace to resume, starting a new build")
+		}
+	}
+	if s.workspace == nil {
+		if s.workspace, err = build.NewWorkspace(root, s.name); err != nil {
+			s.logImage.Error(err)
+			return err
+		}
+	}
+	s.logImage.WithFields(log.Fields{"workspace": s.workspace.Path}).Info("Using workspace")
+
+	if err = s.loadState(); err != nil {
+		s.logImage.Error(err)
+		s.workspace.Unlock()
+		return err
+	}
+
+	// Runs after the builder cleanup, so that nothing is left mounted
+	defer func() {
+		if err != nil {
+			s.logImage.WithFields(log.Fields{"workspace": s.workspace.Path}).Warning("Keeping workspace of failed build")
+			s.workspace.Unlock()
+			return
+		}
+		if rmErr := s.workspace.Remove(); rmErr != nil {
+			s.logImage.Error(rmErr)
+		}
+	}()
+
 	// Always perform cleanup duty.
 	defer s.builder.Cleanup()
 
+	if s.state.Done(build.StageFinalized) {
+		s.logImage.Info("Image was already finalized")
+		return nil
+	}
+
 	// Start building the base parts of the image
 	if err := s.StartImageBuild(); err != nil {
 		s.logImage.Error(err)
@@ -52,3 +102,30 @@ func (s *USpin) Build() error {
 
 	return nil
 }
+
+// loadState will load the build state from the workspace, forgetting the
+// stages that were completed with a different spin or packages file.
+func (s *USpin) loadState() error {
+	var err error
+	if s.state, err = build.LoadState(s.workspace.Path); err != nil {
+		return err
+	}
+	spinHash, err := build.HashFiles(s.spec.Config.Files)
+	if err != nil {
+		return err
+	}
+	packagesHash, err := build.HashFiles(s.spec.PackageFiles)
+	if err != nil {
+		return err
+	}
+
+	s.state.Spin = s.spin
+	spinChanged, packagesChanged := s.state.Update(spinHash, packagesHash)
+	if spinChanged {
+		s.logImage.Warning("Spin file has changed, rebuilding from storage")
+	}
+	if packagesChanged {
+		s.logPackage.Warning("Packages have changed, reinstalling")
+	}
+	return s.state.Save()
+}
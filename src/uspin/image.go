@@ -16,21 +16,35 @@
 
 package main
 
+import (
+	"libuspin/build"
+)
+
 // StartImageBuild will perform all steps up until the point where it is time
 // for the pkg.Manager to step in and populate the rootfs.
 func (s *USpin) StartImageBuild() error {
 	var err error
 
 	s.logImage.Info("Preparing workspace")
-	if err = s.builder.PrepareWorkspace(); err != nil {
+	if err = s.builder.PrepareWorkspace(s.workspace.Path); err != nil {
 		return err
 	}
-
-	s.logImage.Info("Creating storage")
-	if err = s.builder.CreateStorage(); err != nil {
+	if err = s.state.Complete(build.StageWorkspace); err != nil {
 		return err
 	}
 
+	if s.state.Done(build.StageStorage) {
+		s.logImage.Info("Storage already created, skipping")
+	} else {
+		s.logImage.Info("Creating storage")
+		if err = s.builder.CreateStorage(); err != nil {
+			return err
+		}
+		if err = s.state.Complete(build.StageStorage); err != nil {
+			return err
+		}
+	}
+
 	s.logImage.Info("Mounting storage")
 	if err = s.builder.MountStorage(); err != nil {
 		return err
@@ -41,14 +55,27 @@ func (s *USpin) StartImageBuild() error {
 // FinishImageBuild will perform all the last steps required to finalize an
 // image for final "spin".
 func (s *USpin) FinishImageBuild() error {
-	s.logImage.Info("Collecting assets")
-	if err := s.builder.CollectAssets(); err != nil {
-		return err
+	if r, ok := s.builder.(build.Resumable); ok && s.state.Done(build.StageAssets) {
+		s.logImage.Info("Assets already collected, resuming")
+		if err := r.ResumeAssets(); err != nil {
+			return err
+		}
+	} else {
+		s.logImage.Info("Collecting assets")
+		if err := s.builder.CollectAssets(); err != nil {
+			return err
+		}
+		if err := s.state.Complete(build.StageAssets); err != nil {
+			return err
+		}
 	}
 
 	if err := s.builder.UnmountStorage(); err != nil {
 		return err
 	}
 	s.logImage.Info("Finalizing image")
-	return s.builder.FinalizeImage()
+	if err := s.builder.FinalizeImage(); err != nil {
+		return err
+	}
+	return s.state.Complete(build.StageFinalized)
 }
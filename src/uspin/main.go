@@ -18,12 +18,17 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
-	"github.com/solus-project/libosdev/pkg"
 	"libuspin"
 	"libuspin/build"
+	"libuspin/config"
+	"libuspin/packager"
+	"libuspin/spec"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 // Set up the main logger formatting used in USpin
@@ -41,18 +46,29 @@ type USpin struct {
 	logPackage *log.Entry
 
 	builder  build.Builder
-	packager pkg.Manager
+	packager packager.Manager
 	spec     *libuspin.ImageSpec
+
+	name      string           // Name of the build, from the .spin file
+	spin      string           // Absolute path to the .spin file
+	workspace *build.Workspace // Locked workspace for this build
+	resume    bool             // Reuse the workspace of the last failed build
+	state     *build.State     // Completed stages of the build
 }
 
 // NewUSpin will return a new USpin instance which stores global
 // state for the duration of an image spin process.
-func NewUSpin(path string) (*USpin, error) {
-	ret := &USpin{}
+func NewUSpin(path string, opts *config.Options) (*USpin, error) {
+	ret := &USpin{
+		name: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+	}
 	var err error
+	if ret.spin, err = filepath.Abs(path); err != nil {
+		return nil, err
+	}
 
 	// Attempt to get the image spec first
-	if ret.spec, err = libuspin.NewImageSpec(path); err != nil {
+	if ret.spec, err = libuspin.NewImageSpecWithOptions(path, opts); err != nil {
 		return nil, err
 	}
 
@@ -65,11 +81,10 @@ func NewUSpin(path string) (*USpin, error) {
 	// Get our image log
 	ret.logImage = log.WithFields(log.Fields{"imageType": buildType})
 
-	// TODO: Stop hardcoding this!
-	pkgType := pkg.PackageManagerEopkg
+	pkgType := ret.spec.Config.Image.PackageManager
 
 	// Get our package manager
-	if ret.packager, err = pkg.NewManager(pkgType); err != nil {
+	if ret.packager, err = packager.NewManager(ret.spec.Config); err != nil {
 		return nil, err
 	}
 
@@ -87,20 +102,87 @@ func printUsage(exitCode int) {
 		fd = os.Stderr
 	}
 
-	fmt.Fprintf(fd, "%s [image.spin]\n", os.Args[0])
+	fmt.Fprintf(fd, "%s [build] [-resume] [-set key=value] [-lenient] [-workspace dir] [-dump-config] [image.spin]\n", os.Args[0])
 	os.Exit(exitCode)
 }
 
+// printErrors will print the configuration or parse errors one per line, so
+// that every problem can be fixed at once, and exit.
+func printErrors(err error) {
+	switch errs := err.(type) {
+	case config.KeyErrors:
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", flag.Arg(0), e)
+		}
+	case spec.ParseErrors:
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "%v\n", e)
+		}
+	default:
+		log.Fatal(err)
+	}
+	os.Exit(1)
+}
+
+// varFlags collects repeated -set key=value flags
+type varFlags map[string]string
+
+func (v varFlags) String() string {
+	var ret []string
+	for k, val := range v {
+		ret = append(ret, k+"="+val)
+	}
+	return strings.Join(ret, ",")
+}
+
+func (v varFlags) Set(s string) error {
+	fields := strings.SplitN(s, "=", 2)
+	if len(fields) != 2 || strings.TrimSpace(fields[0]) == "" {
+		return fmt.Errorf("Invalid variable, expected key=value: %v", s)
+	}
+	v[strings.TrimSpace(fields[0])] = strings.TrimSpace(fields[1])
+	return nil
+}
+
 func main() {
-	if len(os.Args) < 2 {
+	vars := make(varFlags)
+	flag.Var(vars, "set", "Set a variable for the packages file conditionals, i.e. edition=lite")
+	dumpConfig := flag.Bool("dump-config", false, "Print the fully resolved configuration and exit")
+	lenient := flag.Bool("lenient", false, "Warn about unknown keys in the spin file instead of failing")
+	workspace := flag.String("workspace", "", "Root directory for build workspaces, overriding the spin file")
+	resume := flag.Bool("resume", false, "Resume the last failed build, skipping the stages it completed")
+	flag.Usage = func() { printUsage(1) }
+
+	// build is the default, and currently only, command
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "build" {
+		args = args[1:]
+	}
+	flag.CommandLine.Parse(args)
+
+	if flag.NArg() < 1 {
 		printUsage(1)
 	}
 
-	spin, err := NewUSpin(os.Args[1])
+	opts := &config.Options{Vars: vars, Lenient: *lenient, Workspace: *workspace}
+
+	// Show the result of any extends without building anything
+	if *dumpConfig {
+		conf, err := config.NewWithOptions(flag.Arg(0), opts)
+		if err != nil {
+			printErrors(err)
+		}
+		if err := conf.Dump(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	spin, err := NewUSpin(flag.Arg(0), opts)
 	if err != nil {
-		log.Fatal(err)
-		os.Exit(1)
+		printErrors(err)
 	}
+	spin.resume = *resume
 	if err := spin.Build(); err != nil {
 		os.Exit(1)
 	}
@@ -18,10 +18,17 @@ package main
 
 import (
 	"libuspin"
+	"libuspin/build"
 )
 
-// InstallPackages will install all required packages into the rootfs
+// InstallPackages will install all required packages into the rootfs, unless
+// a resumed build already did so.
 func (s *USpin) InstallPackages() error {
+	if s.state.Done(build.StagePackages) {
+		s.logPackage.Info("Packages already installed, skipping")
+		return nil
+	}
+
 	s.logPackage.Info("Applying operations")
 
 	// First thing first, ensure that it always cleans up within this context,
@@ -48,5 +55,5 @@ func (s *USpin) InstallPackages() error {
 	if err := s.packager.FinalizeRoot(); err != nil {
 		return err
 	}
-	return nil
+	return s.state.Complete(build.StagePackages)
 }